@@ -0,0 +1,413 @@
+package api
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Default badge thresholds, used when a project does not set its own
+const (
+	defaultBadgeLowThreshold  = 50.0
+	defaultBadgeHighThreshold = 80.0
+)
+
+// Badge colors, from shields.io
+const (
+	badgeLabelColor   = "#555"
+	badgeUnknownColor = "#9f9f9f"
+	badgeRed          = "#e05d44"
+	badgeBrightGreen  = "#4c1"
+)
+
+// badgeScale is the color scale between the low and high thresholds
+var badgeScale = []string{"#fe7d37", "#dfb317", "#a4a61d", "#97ca00"}
+
+// badgeMaxLabel bounds the length of a custom label
+const badgeMaxLabel = 64
+
+// badgeMaxAge lets image proxies such as GitHub's camo refresh README badges every few minutes
+const badgeMaxAge = 300
+
+// Badge styles, as named by shields.io
+const (
+	BadgeFlat         = "flat"
+	BadgeFlatSquare   = "flat-square"
+	BadgeForTheBadge  = "for-the-badge"
+	defaultBadgeStyle = BadgeFlat
+)
+
+// badgeStyle describes how a badge style is drawn
+type badgeStyle struct {
+	Height        int
+	Radius        int
+	FontSize      int
+	Padding       float64 // Horizontal space around each text
+	LetterSpacing float64
+	Gradient      bool // Light top-down gradient and text shadow
+	Bold          bool
+	Uppercase     bool
+}
+
+var badgeStyles = map[string]badgeStyle{
+	BadgeFlat:        {Height: 20, Radius: 3, FontSize: 11, Padding: 5, Gradient: true},
+	BadgeFlatSquare:  {Height: 20, FontSize: 11, Padding: 5},
+	BadgeForTheBadge: {Height: 28, FontSize: 10, Padding: 12, LetterSpacing: 1.25, Bold: true, Uppercase: true},
+}
+
+// verdanaWidths are the advance widths, in pixels, of printable ASCII
+// characters in 11px Verdana, the font of shields.io badges
+var verdanaWidths = [95]float64{
+	3.87, 4.33, 5.05, 9.00, 6.99, 11.84, 7.99, 2.95, 4.99, 4.99, 6.99, 9.00, 4.00, 4.99, 4.00, 4.99, // ' ' to '/'
+	6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, // '0' to '9'
+	4.99, 4.99, 9.00, 9.00, 9.00, 6.00, 11.00, // ':' to '@'
+	7.52, 7.54, 7.68, 8.48, 6.96, 6.32, 8.53, 8.27, 4.63, 5.00, 7.62, 6.12, 9.27, // 'A' to 'M'
+	8.23, 8.66, 6.63, 8.66, 7.65, 7.52, 6.78, 8.05, 7.52, 10.88, 7.54, 6.77, 7.54, // 'N' to 'Z'
+	4.99, 4.99, 4.99, 9.00, 6.99, 6.99, // '[' to '`'
+	6.61, 6.85, 5.73, 6.85, 6.55, 3.87, 6.85, 6.96, 3.02, 3.79, 6.51, 3.02, 10.70, // 'a' to 'm'
+	6.96, 6.68, 6.85, 6.85, 4.69, 5.73, 4.33, 6.96, 6.51, 9.00, 6.51, 6.51, 5.78, // 'n' to 'z'
+	6.98, 4.99, 6.98, 9.00, // '{' to '~'
+}
+
+// textWidth estimates the width of a text drawn in a badge style
+func textWidth(text string, style badgeStyle) float64 {
+	width := 0.0
+	for _, r := range text {
+		switch {
+		case r >= ' ' && r <= '~':
+			width += verdanaWidths[r-' ']
+		case r > 0x2e80:
+			width += 11 // CJK and other wide characters
+		default:
+			width += 7
+		}
+	}
+	width *= float64(style.FontSize) / 11
+	if style.Bold {
+		width *= 1.1
+	}
+	return width + style.LetterSpacing*float64(utf8.RuneCountInString(text))
+}
+
+// BadgeHandler handles badge generation
+type BadgeHandler struct {
+	db *gorm.DB
+}
+
+// NewBadgeHandler creates a new badge handler
+func NewBadgeHandler(db *gorm.DB) *BadgeHandler {
+	return &BadgeHandler{db: db}
+}
+
+// ShieldsEndpoint is the response of a shields.io endpoint badge
+type ShieldsEndpoint struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	Style         string `json:"style,omitempty"`
+	CacheSeconds  int    `json:"cacheSeconds"`
+}
+
+// badgeSummary is the label, value and color shown by a coverage badge
+type badgeSummary struct {
+	Label string
+	Value string
+	Color string
+}
+
+// GetBadge generates and returns a coverage badge
+//
+//	@Summary		Coverage badge
+//	@Description	Get an SVG coverage badge for README files. The color goes from red below the project's low threshold to bright green from its high threshold. Answers 304 when the If-None-Match header matches.
+//	@Tags			badges
+//	@Produce		image/svg+xml
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			branch	query		string	false	"Branch (defaults to the project's current branch)"
+//	@Param			token	query		string	false	"Badge token, required when the project has private badges"
+//	@Param			flag	query		string	false	"Only count the jobs uploaded with this flag_name"
+//	@Param			label	query		string	false	"Left-hand text (defaults to coverage)"
+//	@Param			style	query		string	false	"flat (default), flat-square or for-the-badge"
+//	@Success		200		{string}	string	"SVG badge"
+//	@Success		304		{string}	string	"Not modified"
+//	@Failure		404		{string}	string	"Project not found"
+//	@Failure		500		{string}	string	"Database error"
+//	@Router			/projects/{id}/badge.svg [get]
+func (h *BadgeHandler) GetBadge(c *gin.Context) {
+	project, badge, ok := h.loadBadge(c)
+	if !ok {
+		return
+	}
+	writeBadge(c, project, "image/svg+xml; charset=utf-8", []byte(renderBadge(badge.Label, badge.Value, badge.Color, c.Query("style"))))
+}
+
+// GetBadgeJSON returns the coverage badge as a shields.io endpoint
+//
+//	@Summary		Shields.io endpoint badge
+//	@Description	Get the coverage badge in the JSON format of shields.io endpoint badges, for https://img.shields.io/endpoint?url=...
+//	@Tags			badges
+//	@Produce		json
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			branch	query		string	false	"Branch (defaults to the project's current branch)"
+//	@Param			token	query		string	false	"Badge token, required when the project has private badges"
+//	@Param			flag	query		string	false	"Only count the jobs uploaded with this flag_name"
+//	@Param			label	query		string	false	"Left-hand text (defaults to coverage)"
+//	@Param			style	query		string	false	"flat (default), flat-square or for-the-badge"
+//	@Success		200		{object}	ShieldsEndpoint
+//	@Success		304		{string}	string	"Not modified"
+//	@Failure		404		{string}	string	"Project not found"
+//	@Failure		500		{string}	string	"Database error"
+//	@Router			/projects/{id}/badge.json [get]
+func (h *BadgeHandler) GetBadgeJSON(c *gin.Context) {
+	project, badge, ok := h.loadBadge(c)
+	if !ok {
+		return
+	}
+
+	style := c.Query("style")
+	if _, known := badgeStyles[style]; !known {
+		style = ""
+	}
+	body, _ := json.Marshal(ShieldsEndpoint{
+		SchemaVersion: 1,
+		Label:         badge.Label,
+		Message:       badge.Value,
+		Color:         strings.TrimPrefix(badge.Color, "#"),
+		Style:         style,
+		CacheSeconds:  badgeMaxAge,
+	})
+	writeBadge(c, project, "application/json; charset=utf-8", body)
+}
+
+// findBadgeProject finds the project of a badge request. Projects with private
+// badges also require one of their badge tokens in the token parameter, and
+// answer as if they did not exist otherwise. It writes the error response and
+// returns false on failure.
+func (h *BadgeHandler) findBadgeProject(c *gin.Context) (*models.Project, bool) {
+	var project models.Project
+	if err := h.db.Where("id = ?", c.Param("id")).First(&project).Error; err != nil {
+		c.String(http.StatusNotFound, "Project not found")
+		return nil, false
+	}
+
+	if project.PrivateBadges {
+		var token models.BadgeToken
+		if t := c.Query("token"); t == "" || h.db.Where("token = ? AND project_id = ?", t, project.ID).First(&token).Error != nil {
+			c.String(http.StatusNotFound, "Project not found")
+			return nil, false
+		}
+		h.db.Model(&token).Update("last_used", h.db.NowFunc())
+	}
+
+	return &project, true
+}
+
+// loadBadge finds the project of a badge request and what its coverage badge
+// shows. It writes the error response and returns false on failure.
+func (h *BadgeHandler) loadBadge(c *gin.Context) (*models.Project, *badgeSummary, bool) {
+	project, ok := h.findBadgeProject(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	rate, err := badgeCoverage(h.db, project, c.Query("branch"), c.Query("flag"))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return nil, nil, false
+	}
+
+	badge := &badgeSummary{Label: c.DefaultQuery("label", "coverage"), Value: "unknown", Color: badgeUnknownColor}
+	if utf8.RuneCountInString(badge.Label) > badgeMaxLabel {
+		badge.Label = string([]rune(badge.Label)[:badgeMaxLabel])
+	}
+	if rate != nil {
+		low, high := badgeThresholds(project)
+		badge.Value = formatBadgeRate(*rate)
+		badge.Color = badgeColor(*rate, low, high)
+	}
+	return project, badge, true
+}
+
+// writeBadge sends a badge with cache headers suited to README embedding.
+// Private badges may only be cached by the browser or image proxy that asked.
+func writeBadge(c *gin.Context, project *models.Project, contentType string, body []byte) {
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(body))
+	cacheControl := fmt.Sprintf("public, max-age=%d, stale-while-revalidate=60", badgeMaxAge)
+	if project.PrivateBadges {
+		cacheControl = fmt.Sprintf("private, max-age=%d", badgeMaxAge)
+	}
+	c.Header("Cache-Control", cacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// badgeCoverage returns the coverage shown by a badge: the latest build of a
+// branch or, with a flag, the merged coverage of the jobs uploaded with that
+// flag in the latest build that has any. It returns nil when there is no
+// such build. Without a branch, the project's current branch is used.
+func badgeCoverage(db *gorm.DB, project *models.Project, branch, flag string) (*float64, error) {
+	if branch == "" {
+		branch = project.CurrentBranch
+	}
+	if branch == "" && flag == "" {
+		// Projects without a current branch show their latest upload
+		var count int64
+		if err := db.Model(&models.Build{}).Where("project_id = ?", project.ID).Count(&count).Error; err != nil || count == 0 {
+			return nil, err
+		}
+		return &project.CoverageRate, nil
+	}
+
+	query := db.Model(&models.Build{}).Where("builds.project_id = ?", project.ID)
+	if branch != "" {
+		query = query.Where("builds.branch = ?", branch)
+	}
+
+	if flag == "" {
+		var build models.Build
+		if err := query.Select("coverage_rate").Order("builds.build_num DESC").First(&build).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return &build.CoverageRate, nil
+	}
+
+	var buildID uint
+	if err := query.Select("builds.id").
+		Joins("JOIN jobs ON jobs.build_id = builds.id AND jobs.deleted_at IS NULL").
+		Where("jobs.flag_name = ?", flag).
+		Order("builds.build_num DESC").
+		Limit(1).
+		Scan(&buildID).Error; err != nil {
+		return nil, err
+	}
+	if buildID == 0 {
+		return nil, nil
+	}
+
+	var jobFiles []models.JobFile
+	if err := db.Select("job_files.name, job_files.coverage").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ? AND jobs.flag_name = ?", buildID, flag).
+		Order("job_files.id").
+		Find(&jobFiles).Error; err != nil {
+		return nil, err
+	}
+
+	relevant, covered := 0, 0
+	for _, file := range mergeJobFiles(jobFiles) {
+		r, cv := file.counts()
+		relevant += r
+		covered += cv
+	}
+	rate := coverageRate(covered, relevant)
+	return &rate, nil
+}
+
+// badgeThresholds returns the low and high coverage thresholds of a project's badges
+func badgeThresholds(project *models.Project) (low, high float64) {
+	low, high = defaultBadgeLowThreshold, defaultBadgeHighThreshold
+	if project.BadgeLowThreshold != nil {
+		low = *project.BadgeLowThreshold
+	}
+	if project.BadgeHighThreshold != nil {
+		high = *project.BadgeHighThreshold
+	}
+	return low, high
+}
+
+// badgeColor returns red below the low threshold, bright green from the high
+// one, and a step of the orange to green scale in between
+func badgeColor(rate, low, high float64) string {
+	switch {
+	case rate >= high:
+		return badgeBrightGreen
+	case rate < low:
+		return badgeRed
+	}
+	step := int((rate - low) / (high - low) * float64(len(badgeScale)))
+	return badgeScale[min(step, len(badgeScale)-1)]
+}
+
+// formatBadgeRate formats a coverage rate with at most one decimal, e.g. 85.5% or 100%
+func formatBadgeRate(rate float64) string {
+	return strconv.FormatFloat(math.Round(rate*10)/10, 'f', -1, 64) + "%"
+}
+
+// renderBadge draws a two-part badge in one of the shields.io styles, sized to its texts
+func renderBadge(label, value, color, styleName string) string {
+	style, ok := badgeStyles[styleName]
+	if !ok {
+		style = badgeStyles[defaultBadgeStyle]
+	}
+	title := label + ": " + value
+	if style.Uppercase {
+		label, value = strings.ToUpper(label), strings.ToUpper(value)
+	}
+
+	labelWidth := math.Round(textWidth(label, style) + 2*style.Padding)
+	valueWidth := math.Round(textWidth(value, style) + 2*style.Padding)
+	if label == "" {
+		labelWidth = 0
+	}
+	width := labelWidth + valueWidth
+	height := style.Height
+	baseline := float64(height)/2 + float64(style.FontSize)*0.35
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%d" role="img" aria-label="%s">`,
+		width, height, html.EscapeString(title))
+	fmt.Fprintf(&b, `<title>%s</title>`, html.EscapeString(title))
+	if style.Gradient {
+		b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	}
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%g" height="%d" rx="%d" fill="#fff"/></clipPath>`, width, height, style.Radius)
+	fmt.Fprintf(&b, `<g clip-path="url(#r)"><rect width="%g" height="%d" fill="%s"/><rect x="%g" width="%g" height="%d" fill="%s"/>`,
+		labelWidth, height, badgeLabelColor, labelWidth, valueWidth, height, color)
+	if style.Gradient {
+		fmt.Fprintf(&b, `<rect width="%g" height="%d" fill="url(#s)"/>`, width, height)
+	}
+	b.WriteString(`</g>`)
+
+	weight := "normal"
+	if style.Bold {
+		weight = "bold"
+	}
+	fmt.Fprintf(&b, `<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="%d" font-weight="%s" letter-spacing="%g">`,
+		style.FontSize, weight, style.LetterSpacing)
+	for _, part := range []struct {
+		text string
+		x    float64
+	}{
+		{label, labelWidth / 2},
+		{value, labelWidth + valueWidth/2},
+	} {
+		if part.text == "" {
+			continue
+		}
+		text := html.EscapeString(part.text)
+		if style.Gradient {
+			fmt.Fprintf(&b, `<text x="%g" y="%g" fill="#010101" fill-opacity=".3">%s</text>`, part.x, baseline+1, text)
+		}
+		fmt.Fprintf(&b, `<text x="%g" y="%g">%s</text>`, part.x, baseline, text)
+	}
+	b.WriteString(`</g></svg>`)
+
+	return b.String()
+}
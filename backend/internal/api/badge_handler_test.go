@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBadgeColor(t *testing.T) {
+	tests := []struct {
+		rate     float64
+		expected string
+	}{
+		{3, badgeRed},
+		{49.9, badgeRed},
+		{50, "#fe7d37"},
+		{65, "#a4a61d"},
+		{79.9, "#97ca00"},
+		{80, badgeBrightGreen},
+		{100, badgeBrightGreen},
+	}
+	for _, tt := range tests {
+		if got := badgeColor(tt.rate, 50, 80); got != tt.expected {
+			t.Errorf("badgeColor(%v) = %s, expected %s", tt.rate, got, tt.expected)
+		}
+	}
+}
+
+func TestRenderBadge(t *testing.T) {
+	widthPattern := regexp.MustCompile(`^<svg [^>]*width="([0-9.]+)" height="([0-9]+)"`)
+	size := func(svg string) (float64, string) {
+		m := widthPattern.FindStringSubmatch(svg)
+		if m == nil {
+			t.Fatalf("No size in %s", svg)
+		}
+		width, _ := strconv.ParseFloat(m[1], 64)
+		return width, m[2]
+	}
+
+	short, _ := size(renderBadge("coverage", "3%", badgeRed, ""))
+	long, _ := size(renderBadge("e2e coverage of the frontend", "100%", badgeRed, ""))
+	if long <= short+100 {
+		t.Errorf("Expected the badge to grow with its texts, got %v and %v", short, long)
+	}
+
+	svg := renderBadge("cov", "<b>", badgeRed, BadgeForTheBadge)
+	if _, height := size(svg); height != "28" {
+		t.Errorf("Expected for-the-badge to be 28px high, got %s", height)
+	}
+	if !strings.Contains(svg, ">COV<") || !strings.Contains(svg, "&lt;B&gt;") {
+		t.Errorf("Expected upper-case, escaped texts, got %s", svg)
+	}
+
+	if svg := renderBadge("coverage", "90%", badgeBrightGreen, BadgeFlatSquare); strings.Contains(svg, "linearGradient") || !strings.Contains(svg, `rx="0"`) {
+		t.Errorf("Expected a square badge without gradient, got %s", svg)
+	}
+}
+
+func TestGetBadge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	low, high := 20.0, 40.0
+	project := models.Project{Name: "Badge", Token: "badge-token", CurrentBranch: "main", BadgeLowThreshold: &low, BadgeHighThreshold: &high}
+	db.Create(&project)
+
+	db.Create(&models.Build{ProjectID: project.ID, BuildNum: 1, Branch: "main", CoverageRate: 30})
+	db.Create(&models.Build{ProjectID: project.ID, BuildNum: 2, Branch: "dev", CoverageRate: 3})
+
+	// Build 3 on main has a unit job (2/2 lines) and an e2e job (1/4 lines)
+	build := models.Build{ProjectID: project.ID, BuildNum: 3, Branch: "main", CoverageRate: 50}
+	db.Create(&build)
+	unit := models.Job{BuildID: build.ID, FlagName: "unit"}
+	db.Create(&unit)
+	db.Create(&models.JobFile{JobID: unit.ID, Name: "a.go", Coverage: `[1, 1]`})
+	e2e := models.Job{BuildID: build.ID, FlagName: "e2e"}
+	db.Create(&e2e)
+	db.Create(&models.JobFile{JobID: e2e.ID, Name: "b.go", Coverage: `[1, 0, 0, 0]`})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/badge.svg", NewBadgeHandler(db).GetBadge)
+
+	get := func(query string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/projects/"+project.ID+"/badge.svg"+query, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		query, value, color string
+	}{
+		{"", "50%", badgeBrightGreen},
+		{"?branch=dev", "3%", badgeRed},
+		{"?flag=e2e", "25%", "#dfb317"},
+		{"?branch=feature", "unknown", badgeUnknownColor},
+		{"?label=tests&style=flat-square", "50%", badgeBrightGreen},
+	}
+	for _, tt := range tests {
+		w := get(tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tt.query, http.StatusOK, w.Code)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, ">"+tt.value+"<") || !strings.Contains(body, tt.color) {
+			t.Errorf("%s: expected %s in %s, got %s", tt.query, tt.value, tt.color, body)
+		}
+	}
+
+	w = get("?label=tests")
+	if !strings.Contains(w.Body.String(), ">tests<") {
+		t.Errorf("Expected the custom label, got %s", w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "image/svg+xml; charset=utf-8" || !strings.Contains(w.Header().Get("Cache-Control"), "max-age=300") {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+
+	etag := w.Header().Get("ETag")
+	if w := get("?label=tests", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d for a matching ETag, got %d", http.StatusNotModified, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/projects/missing/badge.svg", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestGetBadgeJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Shields", Token: "shields-token", CurrentBranch: "main"}
+	db.Create(&project)
+	db.Create(&models.Build{ProjectID: project.ID, BuildNum: 1, Branch: "main", CoverageRate: 85.54})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/badge.json", NewBadgeHandler(db).GetBadgeJSON)
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/projects/"+project.ID+"/badge.json?label=unit&style=for-the-badge", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var endpoint ShieldsEndpoint
+	json.Unmarshal(w.Body.Bytes(), &endpoint)
+	expected := ShieldsEndpoint{SchemaVersion: 1, Label: "unit", Message: "85.5%", Color: "4c1", Style: "for-the-badge", CacheSeconds: 300}
+	if endpoint != expected {
+		t.Errorf("Expected %+v, got %+v", expected, endpoint)
+	}
+}
+
+func TestGetSparkline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Sparkline", Token: "sparkline-token", CurrentBranch: "main"}
+	db.Create(&project)
+	for i, rate := range []float64{40, 60, 50, 90} {
+		db.Create(&models.Build{ProjectID: project.ID, BuildNum: i + 1, Branch: "main", CoverageRate: rate})
+	}
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/sparkline.svg", NewBadgeHandler(db).GetSparkline)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/projects/"+project.ID+"/sparkline.svg"+query, nil))
+		return w
+	}
+
+	// The last 3 builds, scaled from 50% at the bottom to 90% at the top
+	w = get("?builds=3&width=104&height=24")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	svg := w.Body.String()
+	if !strings.Contains(svg, `<polyline points="2.0,17.0 52.0,22.0 102.0,2.0"`) {
+		t.Errorf("Unexpected sparkline points in %s", svg)
+	}
+	if !strings.Contains(svg, "60% to 90% over 3 builds") || !strings.Contains(svg, badgeBrightGreen) {
+		t.Errorf("Expected the title and color of the latest build, got %s", svg)
+	}
+
+	if w := get("?branch=feature"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "no builds") {
+		t.Errorf("Expected an empty sparkline for a branch without builds, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get("?width=5000"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid width, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestPrivateBadges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	user := models.User{Email: "badges@example.com", Name: "Badges"}
+	db.Create(&user)
+	project := models.Project{Name: "Private", Token: "private-token", CurrentBranch: "main", UserID: user.ID, PrivateBadges: true}
+	db.Create(&project)
+	db.Create(&models.Build{ProjectID: project.ID, BuildNum: 1, Branch: "main", CoverageRate: 90})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	server := &Server{db: db}
+	withUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", user.ID)
+			handler(c)
+		}
+	}
+	router.POST("/api/v1/projects/:id/badge-tokens", withUser(server.CreateBadgeToken))
+	router.DELETE("/api/v1/projects/:id/badge-tokens/:tokenId", withUser(server.DeleteBadgeToken))
+	router.GET("/projects/:id/badge.svg", NewBadgeHandler(db).GetBadge)
+	router.GET("/projects/:id/sparkline.svg", NewBadgeHandler(db).GetSparkline)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without a token, private badges look like missing projects
+	if w := serve("GET", "/projects/"+project.ID+"/badge.svg", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without a token, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve("GET", "/projects/"+project.ID+"/badge.svg?token=private-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the repo token to be refused, got %d", w.Code)
+	}
+
+	w = serve("POST", "/api/v1/projects/"+project.ID+"/badge-tokens", `{"name": "internal README"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var token models.BadgeToken
+	json.Unmarshal(w.Body.Bytes(), &token)
+
+	for _, path := range []string{"/badge.svg", "/sparkline.svg"} {
+		w = serve("GET", "/projects/"+project.ID+path+"?token="+token.Token, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d with a badge token, got %d", path, http.StatusOK, w.Code)
+		}
+		if cache := w.Header().Get("Cache-Control"); !strings.HasPrefix(cache, "private") {
+			t.Errorf("%s: expected a private Cache-Control, got %q", path, cache)
+		}
+	}
+
+	// Revoked tokens stop working
+	if w := serve("DELETE", "/api/v1/projects/"+project.ID+"/badge-tokens/"+strconv.Itoa(int(token.ID)), ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := serve("GET", "/projects/"+project.ID+"/badge.svg?token="+token.Token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d with a revoked token, got %d", http.StatusNotFound, w.Code)
+	}
+}
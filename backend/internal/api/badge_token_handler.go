@@ -0,0 +1,165 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GetBadgeTokens returns the badge tokens of a project
+//
+//	@Summary		List badge tokens
+//	@Description	List the read-only tokens that unlock the badges of a project with private badges. Token values are only returned at creation.
+//	@Tags			badges
+//	@Produce		json
+//	@Param			id	path		string	true	"Project ID"
+//	@Success		200	{array}		models.BadgeToken
+//	@Failure		401	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/badge-tokens [get]
+func (s *Server) GetBadgeTokens(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+
+	// Check project ownership
+	var project models.Project
+	if err := s.db.Where("id = ? AND user_id = ?", projectID, userID).First(&project).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var tokens []models.BadgeToken
+	if err := s.db.Where("project_id = ?", projectID).Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	// Don't send the actual token value in the list
+	for i := range tokens {
+		tokens[i].Token = ""
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateBadgeToken creates a badge token for a project
+//
+//	@Summary		Create a badge token
+//	@Description	Create a read-only token for the badges of a project, to add as ?token= to badge URLs when the project has private badges. The token is only returned once.
+//	@Tags			badges
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"Project ID"
+//	@Param			body	body		object{name=string}	true	"Token name, e.g. the README that embeds it"
+//	@Success		201		{object}	models.BadgeToken
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/badge-tokens [post]
+func (s *Server) CreateBadgeToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+
+	// Check project ownership
+	var project models.Project
+	if err := s.db.Where("id = ? AND user_id = ?", projectID, userID).First(&project).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenStr, err := models.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	token := models.BadgeToken{
+		ProjectID: projectID,
+		Name:      req.Name,
+		Token:     tokenStr,
+	}
+
+	if err := s.db.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	// Return the token only once at creation
+	c.JSON(http.StatusCreated, token)
+}
+
+// DeleteBadgeToken revokes a badge token
+//
+//	@Summary		Revoke a badge token
+//	@Description	Delete a badge token. Badge URLs using it stop working.
+//	@Tags			badges
+//	@Produce		json
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			tokenId	path		int		true	"Badge token ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/badge-tokens/{tokenId} [delete]
+func (s *Server) DeleteBadgeToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+
+	tokenID, err := strconv.ParseUint(c.Param("tokenId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	// Check project ownership
+	var project models.Project
+	if err := s.db.Where("id = ? AND user_id = ?", projectID, userID).First(&project).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	result := s.db.Where("id = ? AND project_id = ?", tokenID, projectID).Delete(&models.BadgeToken{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete token"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token deleted successfully"})
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// coberturaDocType is the DTD of the Cobertura reports read by GitLab and Azure DevOps
+const coberturaDocType = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+// coberturaCoverage is the root element of a Cobertura report
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+// coberturaPackage groups the files of a directory
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+
+	lines, coveredLines, branches, coveredBranches int
+}
+
+// coberturaClass is the coverage of a single file
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity int               `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+// coberturaMethod is the coverage of a function and of the lines it spans
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+// coberturaLine is the hit count of a line, with its branch coverage if it has branches
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int    `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// writeCobertura writes coverage as a Cobertura XML report, with a package per directory
+func writeCobertura(w io.Writer, export *coverageExport) error {
+	report := coberturaCoverage{
+		Version:   "librecov",
+		Timestamp: export.Build.CreatedAt.UnixMilli(),
+		Sources:   []string{"."},
+	}
+
+	packages := make(map[string]*coberturaPackage)
+	var order []string
+	for _, name := range sortedFileNames(export.Files) {
+		file := export.Files[name]
+		dir := path.Dir(name)
+		pkg, ok := packages[dir]
+		if !ok {
+			pkg = &coberturaPackage{Name: dir}
+			packages[dir] = pkg
+			order = append(order, dir)
+		}
+
+		lines, _, _ := coberturaLines(file, 1, len(file.Lines))
+		relevant, covered := file.counts()
+		branches, coveredBranches := file.branchCounts()
+		class := coberturaClass{
+			Name:       strings.TrimSuffix(path.Base(name), path.Ext(name)),
+			Filename:   name,
+			LineRate:   coberturaRate(covered, relevant),
+			BranchRate: coberturaRate(coveredBranches, branches),
+			Lines:      lines,
+		}
+		for _, fn := range export.Functions[name] {
+			methodLines, methodBranches, methodCoveredBranches := coberturaLines(file, fn.StartLine, fn.EndLine)
+			methodCovered := 0
+			for _, line := range methodLines {
+				if line.Hits > 0 {
+					methodCovered++
+				}
+			}
+			class.Methods = append(class.Methods, coberturaMethod{
+				Name:       fn.Name,
+				LineRate:   coberturaRate(methodCovered, len(methodLines)),
+				BranchRate: coberturaRate(methodCoveredBranches, methodBranches),
+				Lines:      methodLines,
+			})
+		}
+
+		pkg.Classes = append(pkg.Classes, class)
+		pkg.lines += relevant
+		pkg.coveredLines += covered
+		pkg.branches += branches
+		pkg.coveredBranches += coveredBranches
+	}
+
+	for _, dir := range order {
+		pkg := packages[dir]
+		pkg.LineRate = coberturaRate(pkg.coveredLines, pkg.lines)
+		pkg.BranchRate = coberturaRate(pkg.coveredBranches, pkg.branches)
+		report.Packages = append(report.Packages, *pkg)
+		report.LinesValid += pkg.lines
+		report.LinesCovered += pkg.coveredLines
+		report.BranchesValid += pkg.branches
+		report.BranchesCovered += pkg.coveredBranches
+	}
+	report.LineRate = coberturaRate(report.LinesCovered, report.LinesValid)
+	report.BranchRate = coberturaRate(report.BranchesCovered, report.BranchesValid)
+
+	if _, err := io.WriteString(w, xml.Header+coberturaDocType+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// coberturaLines lists the relevant lines of a file between two 1-based line numbers,
+// with the number of branches and covered branches on them
+func coberturaLines(file *fileCoverage, from, to int) (lines []coberturaLine, totalBranches, coveredBranches int) {
+	branches := file.lineBranches()
+
+	for number := max(from, 1); number <= min(to, len(file.Lines)); number++ {
+		hits := file.hitsAt(number)
+		if hits == lineNotRelevant {
+			continue
+		}
+		line := coberturaLine{Number: number, Hits: hits}
+		if counts, ok := branches[number]; ok {
+			line.Branch = true
+			line.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", counts[1]*100/counts[0], counts[1], counts[0])
+			totalBranches += counts[0]
+			coveredBranches += counts[1]
+		}
+		lines = append(lines, line)
+	}
+	return lines, totalBranches, coveredBranches
+}
+
+// coberturaRate formats a ratio between 0 and 1 as a plain decimal, counting
+// files without lines as fully covered like Cobertura
+func coberturaRate(covered, total int) string {
+	if total == 0 {
+		return strconv.FormatFloat(1, 'f', 4, 64)
+	}
+	return strconv.FormatFloat(float64(covered)/float64(total), 'f', 4, 64)
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BuildRef is the short description of a build used in comparison results
+type BuildRef struct {
+	ID           uint    `json:"id"`
+	BuildNum     int     `json:"build_num"`
+	Branch       string  `json:"branch"`
+	CommitSHA    string  `json:"commit_sha"`
+	CoverageRate float64 `json:"coverage_rate"`
+}
+
+// FileRate is a file name with its coverage rate
+type FileRate struct {
+	Name         string  `json:"name"`
+	CoverageRate float64 `json:"coverage_rate"`
+}
+
+// FileDiff describes how the coverage of a file changed between two builds
+type FileDiff struct {
+	Name                string  `json:"name"`
+	BaseRate            float64 `json:"base_rate"`
+	HeadRate            float64 `json:"head_rate"`
+	Delta               float64 `json:"delta"`
+	NewlyCoveredLines   []int   `json:"newly_covered_lines"`
+	NewlyUncoveredLines []int   `json:"newly_uncovered_lines"`
+}
+
+// BuildComparison is the coverage difference between two builds
+type BuildComparison struct {
+	Base          BuildRef   `json:"base"`
+	Head          BuildRef   `json:"head"`
+	CoverageDelta float64    `json:"coverage_delta"`
+	ExcludedFlaky bool       `json:"excluded_flaky"` // Flaky lines were left out of the comparison
+	AddedFiles    []FileRate `json:"added_files"`
+	RemovedFiles  []FileRate `json:"removed_files"`
+	ChangedFiles  []FileDiff `json:"changed_files"`
+}
+
+// Compare returns the coverage difference between two builds of a project
+//
+//	@Summary		Compare two builds
+//	@Description	Compare the coverage of two builds of a project, identified by build number or commit SHA
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			base	query		string	true	"Base build number or commit SHA"
+//	@Param			head	query		string	true	"Head build number or commit SHA"
+//	@Param			exclude_flaky	query		bool	false	"Leave flaky lines out (defaults to the project setting)"
+//	@Success		200		{object}	BuildComparison
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/compare [get]
+func (h *BuildHandler) Compare(c *gin.Context) {
+	projectID := c.Param("id")
+	baseRef := c.Query("base")
+	headRef := c.Query("head")
+
+	if baseRef == "" || headRef == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Both base and head are required"})
+		return
+	}
+
+	if !isBuildRef(baseRef) || !isBuildRef(headRef) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base and head must be build numbers or commit SHAs"})
+		return
+	}
+
+	base, err := findBuildByRef(h.db, projectID, baseRef)
+	if err != nil {
+		respondBuildLookupError(c, err, "Base build not found")
+		return
+	}
+	head, err := findBuildByRef(h.db, projectID, headRef)
+	if err != nil {
+		respondBuildLookupError(c, err, "Head build not found")
+		return
+	}
+
+	baseFiles, err := loadBuildCoverage(h.db, base.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build files"})
+		return
+	}
+	headFiles, err := loadBuildCoverage(h.db, head.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build files"})
+		return
+	}
+
+	excludeFlaky, err := shouldExcludeFlaky(h.db, projectID, c.Query("exclude_flaky"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flaky lines"})
+		return
+	}
+	if excludeFlaky {
+		flaky, err := loadFlakyLines(h.db, projectID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flaky lines"})
+			return
+		}
+		excludeFlakyLines(baseFiles, flaky)
+		excludeFlakyLines(headFiles, flaky)
+	}
+
+	comparison := compareFiles(baseFiles, headFiles)
+	comparison.Base = newBuildRef(base)
+	comparison.Head = newBuildRef(head)
+	comparison.CoverageDelta = head.CoverageRate - base.CoverageRate
+	if excludeFlaky {
+		comparison.ExcludedFlaky = true
+		comparison.CoverageDelta = totalCoverageRate(headFiles) - totalCoverageRate(baseFiles)
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+// isBuildRef reports whether a ref is a build number or a possibly abbreviated commit SHA
+func isBuildRef(ref string) bool {
+	if ref == "" {
+		return false
+	}
+	for _, r := range ref {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// findBuildByRef resolves a build of a project from a build number or a commit SHA.
+// A commit SHA may be abbreviated; the most recent matching build wins. A ref made
+// only of digits is looked up as a build number first, then as a commit SHA.
+func findBuildByRef(db *gorm.DB, projectID, ref string) (*models.Build, error) {
+	var build models.Build
+
+	if num, err := strconv.Atoi(ref); err == nil {
+		err := db.Where("project_id = ? AND build_num = ?", projectID, num).
+			Order("build_num DESC").First(&build).Error
+		if err == nil {
+			return &build, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	err := db.Where("project_id = ?", projectID).
+		Where("commit_sha LIKE ? ESCAPE '\\'", escapeLike(ref)+"%").
+		Order("build_num DESC").First(&build).Error
+	if err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, for use with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// shouldExcludeFlaky resolves an exclude_flaky query parameter, falling back to the project setting
+func shouldExcludeFlaky(db *gorm.DB, projectID, param string) (bool, error) {
+	if param != "" {
+		return param == "true", nil
+	}
+	var project models.Project
+	if err := db.Select("exclude_flaky_lines").Where("id = ?", projectID).First(&project).Error; err != nil {
+		return false, err
+	}
+	return project.ExcludeFlakyLines, nil
+}
+
+// respondBuildLookupError writes the error response for a failed build lookup
+func respondBuildLookupError(c *gin.Context, err error, notFound string) {
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+	}
+}
+
+// newBuildRef summarizes a build for comparison results
+func newBuildRef(b *models.Build) BuildRef {
+	return BuildRef{
+		ID:           b.ID,
+		BuildNum:     b.BuildNum,
+		Branch:       b.Branch,
+		CommitSHA:    b.CommitSHA,
+		CoverageRate: b.CoverageRate,
+	}
+}
+
+// compareFiles computes added, removed and changed files between two sets of build files
+func compareFiles(baseFiles, headFiles map[string]*fileCoverage) BuildComparison {
+	comparison := BuildComparison{
+		AddedFiles:   []FileRate{},
+		RemovedFiles: []FileRate{},
+		ChangedFiles: []FileDiff{},
+	}
+
+	for _, name := range sortedFileNames(headFiles) {
+		headFile := headFiles[name]
+		baseFile, ok := baseFiles[name]
+		if !ok {
+			comparison.AddedFiles = append(comparison.AddedFiles, FileRate{Name: name, CoverageRate: headFile.rate()})
+			continue
+		}
+
+		diff := diffFile(baseFile, headFile)
+		if diff.Delta != 0 || len(diff.NewlyCoveredLines) > 0 || len(diff.NewlyUncoveredLines) > 0 {
+			comparison.ChangedFiles = append(comparison.ChangedFiles, diff)
+		}
+	}
+
+	for _, name := range sortedFileNames(baseFiles) {
+		if _, ok := headFiles[name]; !ok {
+			comparison.RemovedFiles = append(comparison.RemovedFiles, FileRate{Name: name, CoverageRate: baseFiles[name].rate()})
+		}
+	}
+
+	return comparison
+}
+
+// diffFile compares the line coverage of the same file in two builds
+func diffFile(baseFile, headFile *fileCoverage) FileDiff {
+	diff := FileDiff{
+		Name:                headFile.Name,
+		BaseRate:            baseFile.rate(),
+		HeadRate:            headFile.rate(),
+		NewlyCoveredLines:   []int{},
+		NewlyUncoveredLines: []int{},
+	}
+	diff.Delta = diff.HeadRate - diff.BaseRate
+
+	for i, hits := range headFile.Lines {
+		if hits == lineNotRelevant {
+			continue
+		}
+		line := i + 1
+		wasCovered := baseFile.hitsAt(line) > 0
+		if hits > 0 && !wasCovered {
+			diff.NewlyCoveredLines = append(diff.NewlyCoveredLines, line)
+		} else if hits == 0 && wasCovered {
+			diff.NewlyUncoveredLines = append(diff.NewlyUncoveredLines, line)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// createTestBuild stores a build with a single job holding the given files (name -> coverage JSON)
+func createTestBuild(t *testing.T, db *gorm.DB, projectID string, buildNum int, branch, sha string, files map[string]string) models.Build {
+	t.Helper()
+
+	build := models.Build{ProjectID: projectID, BuildNum: buildNum, Branch: branch, CommitSHA: sha}
+	if err := db.Create(&build).Error; err != nil {
+		t.Fatalf("Failed to create build: %v", err)
+	}
+
+	job := models.Job{BuildID: build.ID, JobNumber: "1.1"}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	total, covered := 0, 0
+	for name, coverage := range files {
+		fc := &fileCoverage{Name: name, Lines: parseLineHits(coverage)}
+		relevant, hit := fc.counts()
+		total += relevant
+		covered += hit
+
+		file := models.JobFile{JobID: job.ID, Name: name, Coverage: coverage, CoverageRate: fc.rate()}
+		if err := db.Create(&file).Error; err != nil {
+			t.Fatalf("Failed to create job file: %v", err)
+		}
+	}
+
+	build.CoverageRate = coverageRate(covered, total)
+	db.Save(&build)
+	job.CoverageRate = build.CoverageRate
+	db.Save(&job)
+
+	return build
+}
+
+func TestCompareBuilds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Compare", Token: "compare-token"}
+	db.Create(&project)
+
+	createTestBuild(t, db, project.ID, 412, "main", "aaaa1111", map[string]string{
+		"main.go":   `[null, 1, 0, 1]`,
+		"legacy.go": `[1, 1]`,
+	})
+	createTestBuild(t, db, project.ID, 415, "main", "bbbb2222", map[string]string{
+		"main.go": `[null, 0, 3, 1]`,
+		"new.go":  `[1, 0]`,
+	})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/compare", NewBuildHandler(db).Compare)
+
+	req := httptest.NewRequest("GET", "/projects/"+project.ID+"/compare?base=412&head=bbbb", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result BuildComparison
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if result.Base.BuildNum != 412 || result.Head.BuildNum != 415 {
+		t.Errorf("Expected builds 412 and 415, got %d and %d", result.Base.BuildNum, result.Head.BuildNum)
+	}
+	if len(result.AddedFiles) != 1 || result.AddedFiles[0].Name != "new.go" {
+		t.Errorf("Expected new.go to be added, got %+v", result.AddedFiles)
+	}
+	if len(result.RemovedFiles) != 1 || result.RemovedFiles[0].Name != "legacy.go" {
+		t.Errorf("Expected legacy.go to be removed, got %+v", result.RemovedFiles)
+	}
+	if len(result.ChangedFiles) != 1 {
+		t.Fatalf("Expected 1 changed file, got %+v", result.ChangedFiles)
+	}
+
+	diff := result.ChangedFiles[0]
+	if len(diff.NewlyCoveredLines) != 1 || diff.NewlyCoveredLines[0] != 3 {
+		t.Errorf("Expected line 3 to be newly covered, got %v", diff.NewlyCoveredLines)
+	}
+	if len(diff.NewlyUncoveredLines) != 1 || diff.NewlyUncoveredLines[0] != 2 {
+		t.Errorf("Expected line 2 to be newly uncovered, got %v", diff.NewlyUncoveredLines)
+	}
+}
+
+func TestCompareBuildsNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/compare", NewBuildHandler(db).Compare)
+
+	req := httptest.NewRequest("GET", "/projects/missing/compare?base=1&head=2", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCompareBuildsByRef(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Compare refs", Token: "compare-refs-token"}
+	db.Create(&project)
+
+	createTestBuild(t, db, project.ID, 1, "main", "1234567abc", map[string]string{"main.go": `[1, 0]`})
+	createTestBuild(t, db, project.ID, 2, "main", "abcdef0123", map[string]string{"main.go": `[1, 1]`})
+
+	router := gin.New()
+	router.GET("/projects/:id/compare", NewBuildHandler(db).Compare)
+
+	// 1234567 is not a build number, so it resolves as an abbreviated SHA
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/projects/"+project.ID+"/compare?base=1234567&head=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var result BuildComparison
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Base.BuildNum != 1 || result.Head.BuildNum != 2 {
+		t.Errorf("Expected builds 1 and 2, got %d and %d", result.Base.BuildNum, result.Head.BuildNum)
+	}
+
+	for _, ref := range []string{"%25", "ab_d", "main"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/projects/"+project.ID+"/compare?base="+ref+"&head=2", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for ref %q, got %d", http.StatusBadRequest, ref, w.Code)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Complexity metrics
+const (
+	MetricCyclomatic = "cyclomatic"
+	MetricCognitive  = "cognitive"
+)
+
+// complexityEntry is a function reported by a complexity tool
+type complexityEntry struct {
+	File       string
+	Name       string
+	StartLine  int
+	EndLine    int // 0 when the tool does not report it
+	Complexity int
+}
+
+// gocycloStat is an entry of the JSON output of gocyclo and gocognit
+type gocycloStat struct {
+	PkgName    string `json:"PkgName"`
+	FuncName   string `json:"FuncName"`
+	Complexity int    `json:"Complexity"`
+	Pos        struct {
+		Filename string `json:"Filename"`
+		Line     int    `json:"Line"`
+	} `json:"Pos"`
+}
+
+// ComplexityUploadResult summarizes a complexity upload
+type ComplexityUploadResult struct {
+	JobID          uint     `json:"job_id"`
+	Metric         string   `json:"metric"`
+	Functions      int      `json:"functions"`
+	UnmatchedFiles []string `json:"unmatched_files"` // Reported files that are not part of the job
+}
+
+// FunctionCrap is the CRAP score of a function of a build
+type FunctionCrap struct {
+	File         string  `json:"file"`
+	Name         string  `json:"name"`
+	StartLine    int     `json:"start_line"`
+	EndLine      int     `json:"end_line"`
+	Metric       string  `json:"metric"`
+	Complexity   int     `json:"complexity"`
+	CoverageRate float64 `json:"coverage_rate"`
+	Crap         float64 `json:"crap"`
+}
+
+// BuildComplexity lists the functions of a build with the worst CRAP scores
+type BuildComplexity struct {
+	BuildID   uint           `json:"build_id"`
+	Functions int            `json:"functions"`
+	Threshold float64        `json:"threshold"`
+	Crappy    int            `json:"crappy"` // Functions scoring above the threshold
+	Worst     []FunctionCrap `json:"worst"`
+}
+
+// UploadComplexity stores per-function complexity data for the files of a job
+//
+//	@Summary		Upload complexity metrics
+//	@Description	Upload the output of gocyclo -json, gocognit -json or lizard --csv for a job created by a coverage upload. The report is sent as the request body or as the "file" form field.
+//	@Tags			coverage
+//	@Accept			json
+//	@Produce		json
+//	@Param			repo_token	query		string	true	"Project repo token"
+//	@Param			job_id		query		int		true	"Job ID returned by the coverage upload"
+//	@Param			format		query		string	true	"gocyclo, gocognit or lizard"
+//	@Success		200			{object}	ComplexityUploadResult
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/upload/complexity [post]
+func (h *JobHandler) UploadComplexity(c *gin.Context) {
+	upload, ok := h.openReportUpload(c)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+
+	var entries []complexityEntry
+	var metric string
+	var err error
+	switch reportParam(c, "format") {
+	case "gocyclo":
+		metric = MetricCyclomatic
+		entries, err = parseGocycloJSON(upload.Body)
+	case "gocognit":
+		metric = MetricCognitive
+		entries, err = parseGocycloJSON(upload.Body)
+	case "lizard":
+		metric = MetricCyclomatic
+		entries, err = parseLizardCSV(upload.Body)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be gocyclo, gocognit or lizard"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid complexity report", "details": err.Error()})
+		return
+	}
+
+	var jobFiles []models.JobFile
+	if err := h.db.Select("id, name, coverage").Where("job_id = ?", upload.Job.ID).Find(&jobFiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job files"})
+		return
+	}
+
+	functions, unmatched := matchComplexity(jobFiles, entries, metric)
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		fileIDs := make([]uint, len(jobFiles))
+		for i, jf := range jobFiles {
+			fileIDs[i] = jf.ID
+		}
+		// A new report of the same metric replaces the previous one
+		if err := tx.Unscoped().Where("job_file_id IN ? AND metric = ?", fileIDs, metric).
+			Delete(&models.FunctionComplexity{}).Error; err != nil {
+			return err
+		}
+		if len(functions) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&functions, 500).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store complexity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ComplexityUploadResult{
+		JobID:          upload.Job.ID,
+		Metric:         metric,
+		Functions:      len(functions),
+		UnmatchedFiles: unmatched,
+	})
+}
+
+// Complexity lists the functions of a build with the worst CRAP scores
+//
+//	@Summary		Worst functions by CRAP score
+//	@Description	Get the functions of a build ranked by CRAP score, which combines complexity with the coverage of the function's lines. Cyclomatic complexity is used when available, cognitive complexity otherwise.
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Build ID"
+//	@Param			limit		query		int		false	"Number of functions (default 20, max 500)"
+//	@Param			threshold	query		number	false	"CRAP score above which a function is counted as crappy (default 30)"
+//	@Success		200			{object}	BuildComplexity
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/complexity [get]
+func (h *BuildHandler) Complexity(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid build ID"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	limit = min(limit, 500)
+
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "30"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+		return
+	}
+
+	if err := h.db.Select("id").First(&models.Build{}, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	scores, err := buildCrapScores(h.db, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute CRAP scores"})
+		return
+	}
+
+	result := BuildComplexity{
+		BuildID:   uint(id),
+		Functions: len(scores),
+		Threshold: threshold,
+		Worst:     scores,
+	}
+	for _, s := range scores {
+		if s.Crap > threshold {
+			result.Crappy++
+		}
+	}
+	if len(result.Worst) > limit {
+		result.Worst = result.Worst[:limit]
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseGocycloJSON parses the JSON output of gocyclo or gocognit
+func parseGocycloJSON(r io.Reader) ([]complexityEntry, error) {
+	var stats []gocycloStat
+	if err := json.NewDecoder(r).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	entries := make([]complexityEntry, 0, len(stats))
+	for _, s := range stats {
+		if s.Pos.Filename == "" || s.FuncName == "" {
+			return nil, fmt.Errorf("entry without file or function name")
+		}
+		entries = append(entries, complexityEntry{
+			File:       s.Pos.Filename,
+			Name:       s.FuncName,
+			StartLine:  s.Pos.Line,
+			Complexity: s.Complexity,
+		})
+	}
+	return entries, nil
+}
+
+// parseLizardCSV parses the output of lizard --csv. Columns are NLOC, CCN,
+// token count, parameter count, length, location, file, function name, long
+// name, start line and end line. A header row is skipped when present.
+func parseLizardCSV(r io.Reader) ([]complexityEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	entries := []complexityEntry{}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 11 {
+			return nil, fmt.Errorf("row %d: expected 11 columns, got %d", row, len(record))
+		}
+
+		ccn, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if row == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("row %d: invalid CCN %q", row, record[1])
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(record[9]))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid start line %q", row, record[9])
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(record[10]))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid end line %q", row, record[10])
+		}
+
+		entries = append(entries, complexityEntry{
+			File:       record[6],
+			Name:       record[7],
+			StartLine:  start,
+			EndLine:    end,
+			Complexity: ccn,
+		})
+	}
+	return entries, nil
+}
+
+// matchComplexity attaches complexity entries to the job files they belong
+// to, matching paths like findFileByPath. Missing end lines are inferred from
+// the start of the next function of the file, or from the end of the file.
+// It also returns the reported files that are not part of the job, or that
+// match several of its files.
+func matchComplexity(jobFiles []models.JobFile, entries []complexityEntry, metric string) ([]models.FunctionComplexity, []string) {
+	files := make(map[string]*fileCoverage, len(jobFiles))
+	fileIDs := make(map[*fileCoverage]uint, len(jobFiles))
+	for _, jf := range jobFiles {
+		file := &fileCoverage{Name: jf.Name, Lines: parseLineHits(jf.Coverage)}
+		files[jf.Name] = file
+		fileIDs[file] = jf.ID
+	}
+
+	byFile := make(map[*fileCoverage][]complexityEntry)
+	unmatchedSet := make(map[string]bool)
+	for _, e := range entries {
+		file := findFileByPath(files, strings.TrimPrefix(e.File, "./"))
+		if file == nil {
+			unmatchedSet[e.File] = true
+			continue
+		}
+		byFile[file] = append(byFile[file], e)
+	}
+
+	functions := []models.FunctionComplexity{}
+	for _, name := range sortedFileNames(files) {
+		file := files[name]
+		fileEntries := byFile[file]
+		sort.SliceStable(fileEntries, func(i, j int) bool { return fileEntries[i].StartLine < fileEntries[j].StartLine })
+
+		for i, e := range fileEntries {
+			end := e.EndLine
+			if end == 0 {
+				end = max(len(file.Lines), e.StartLine)
+				if i+1 < len(fileEntries) && fileEntries[i+1].StartLine > e.StartLine {
+					end = fileEntries[i+1].StartLine - 1
+				}
+			}
+			functions = append(functions, models.FunctionComplexity{
+				JobFileID:  fileIDs[file],
+				Name:       e.Name,
+				StartLine:  e.StartLine,
+				EndLine:    end,
+				Metric:     metric,
+				Complexity: e.Complexity,
+			})
+		}
+	}
+
+	unmatched := make([]string, 0, len(unmatchedSet))
+	for name := range unmatchedSet {
+		unmatched = append(unmatched, name)
+	}
+	sort.Strings(unmatched)
+
+	return functions, unmatched
+}
+
+// buildCrapScores computes the CRAP score of every function of a build,
+// worst first. Functions reported by several jobs are scored once against
+// the merged coverage of the build.
+func buildCrapScores(db *gorm.DB, buildID uint) ([]FunctionCrap, error) {
+	var rows []struct {
+		File       string
+		Name       string
+		StartLine  int
+		EndLine    int
+		Metric     string
+		Complexity int
+	}
+	if err := db.Table("function_complexities").
+		Select("job_files.name AS file, function_complexities.name, function_complexities.start_line, function_complexities.end_line, function_complexities.metric, function_complexities.complexity").
+		Joins("JOIN job_files ON job_files.id = function_complexities.job_file_id AND job_files.deleted_at IS NULL").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ? AND function_complexities.deleted_at IS NULL", buildID).
+		Order("function_complexities.id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	files, err := loadBuildCoverage(db, buildID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep one entry per function, preferring cyclomatic complexity
+	type functionKey struct {
+		File  string
+		Name  string
+		Start int
+	}
+	picked := make(map[functionKey]int)
+	kept := rows[:0]
+	for _, r := range rows {
+		key := functionKey{r.File, r.Name, r.StartLine}
+		if i, ok := picked[key]; ok {
+			if r.Metric == MetricCyclomatic && kept[i].Metric != MetricCyclomatic {
+				kept[i] = r
+			}
+			continue
+		}
+		picked[key] = len(kept)
+		kept = append(kept, r)
+	}
+
+	scores := make([]FunctionCrap, 0, len(kept))
+	for _, r := range kept {
+		relevant, covered := 0, 0
+		if file, ok := files[r.File]; ok {
+			for line := r.StartLine; line <= r.EndLine; line++ {
+				hits := file.hitsAt(line)
+				if hits == lineNotRelevant {
+					continue
+				}
+				relevant++
+				if hits > 0 {
+					covered++
+				}
+			}
+		}
+		rate := coverageRate(covered, relevant)
+
+		scores = append(scores, FunctionCrap{
+			File:         r.File,
+			Name:         r.Name,
+			StartLine:    r.StartLine,
+			EndLine:      r.EndLine,
+			Metric:       r.Metric,
+			Complexity:   r.Complexity,
+			CoverageRate: rate,
+			Crap:         crapScore(r.Complexity, rate),
+		})
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Crap != scores[j].Crap {
+			return scores[i].Crap > scores[j].Crap
+		}
+		if scores[i].File != scores[j].File {
+			return scores[i].File < scores[j].File
+		}
+		return scores[i].StartLine < scores[j].StartLine
+	})
+
+	return scores, nil
+}
+
+// crapScore computes comp^2 * (1 - cov)^3 + comp, with cov the coverage rate
+// of the function as a fraction
+func crapScore(complexity int, rate float64) float64 {
+	comp := float64(complexity)
+	uncovered := 1 - rate/100
+	score := comp*comp*math.Pow(uncovered, 3) + comp
+	return math.Round(score*100) / 100
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestComplexityUploadAndCrap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Complexity", Token: "complexity-token"}
+	db.Create(&project)
+
+	build := createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{
+		"pkg/a.go": `[1, 1, 0, 0, null, 0, 0, 0]`,
+	})
+	var job models.Job
+	db.Where("build_id = ?", build.ID).First(&job)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	jobHandler := NewJobHandler(db)
+	router.POST("/upload/complexity", jobHandler.UploadComplexity)
+	router.GET("/builds/:id/complexity", NewBuildHandler(db).Complexity)
+
+	upload := func(format, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		url := fmt.Sprintf("/upload/complexity?repo_token=complexity-token&job_id=%d&format=%s", job.ID, format)
+		req := httptest.NewRequest("POST", url, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = upload("gocyclo", `[
+		{"PkgName": "pkg", "FuncName": "F", "Complexity": 1, "Pos": {"Filename": "/ci/repo/pkg/a.go", "Line": 1}},
+		{"PkgName": "pkg", "FuncName": "G", "Complexity": 10, "Pos": {"Filename": "/ci/repo/pkg/a.go", "Line": 5}},
+		{"PkgName": "other", "FuncName": "H", "Complexity": 3, "Pos": {"Filename": "other/b.go", "Line": 1}}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result ComplexityUploadResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Functions != 2 || len(result.UnmatchedFiles) != 1 || result.UnmatchedFiles[0] != "other/b.go" {
+		t.Errorf("Expected 2 functions and other/b.go unmatched, got %+v", result)
+	}
+
+	// Cognitive complexity must not override cyclomatic complexity in CRAP scores
+	if w := upload("gocognit", `[{"FuncName": "G", "Complexity": 20, "Pos": {"Filename": "pkg/a.go", "Line": 5}}]`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/complexity", build.ID), nil)
+	router.ServeHTTP(w, req)
+
+	var complexity BuildComplexity
+	json.Unmarshal(w.Body.Bytes(), &complexity)
+	if complexity.Functions != 2 || complexity.Crappy != 1 {
+		t.Fatalf("Expected 2 functions with 1 crappy, got %+v", complexity)
+	}
+
+	worst := complexity.Worst[0]
+	if worst.Name != "G" || worst.EndLine != 8 || worst.Metric != MetricCyclomatic || worst.Crap != 110 {
+		t.Errorf("Expected G (lines 5-8, uncovered) to score 110, got %+v", worst)
+	}
+	if f := complexity.Worst[1]; f.Name != "F" || f.EndLine != 4 || f.CoverageRate != 50 || f.Crap != 1.13 {
+		t.Errorf("Expected F (lines 1-4, half covered) to score 1.13, got %+v", f)
+	}
+
+	if w := upload("pmd", `[]`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestParseLizardCSV(t *testing.T) {
+	report := `NLOC,CCN,token,PARAM,length,location,file,function,long_name,start,end
+4,2,20,1,5,"main@3-7@src/main.c",src/main.c,main,"main( int argc)",3,7
+`
+	entries, err := parseLizardCSV(strings.NewReader(report))
+	if err != nil {
+		t.Fatalf("Failed to parse lizard CSV: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.File != "src/main.c" || e.Name != "main" || e.Complexity != 2 || e.StartLine != 3 || e.EndLine != 7 {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	if _, err := parseLizardCSV(strings.NewReader("4,x,20,1,5,loc,f.c,f,f(),1,2\n4,y,20,1,5,loc,f.c,f,f(),1,2\n")); err == nil {
+		t.Error("Expected an error for an invalid CCN")
+	}
+}
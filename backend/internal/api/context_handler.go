@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TestContext is a test and the requested lines it executes
+type TestContext struct {
+	Name  string `json:"name"`
+	Lines []int  `json:"lines"`
+}
+
+// LineContexts lists the tests that execute some lines of a file
+type LineContexts struct {
+	BuildID uint          `json:"build_id"`
+	Path    string        `json:"path"`
+	File    string        `json:"file"` // Matched file name, which may be longer than the requested path
+	Lines   string        `json:"lines,omitempty"`
+	Tests   []TestContext `json:"tests"`
+}
+
+// Contexts returns the tests that execute lines of a file in a build
+//
+//	@Summary		Tests covering lines
+//	@Description	Get the tests that execute the given lines of a file, from the per-line test contexts uploaded with the build
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Build ID"
+//	@Param			path	query		string	true	"File path"
+//	@Param			lines	query		string	false	"Line ranges such as 40-55,60 (defaults to the whole file)"
+//	@Success		200		{object}	LineContexts
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/contexts [get]
+func (h *BuildHandler) Contexts(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid build ID"})
+		return
+	}
+
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+
+	var ranges [][2]int
+	if lines := c.Query("lines"); lines != "" {
+		ranges, err = parseLineRanges(lines)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.db.Select("id").First(&models.Build{}, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	file, err := resolveFilePath(h.db, []uint{uint(id)}, path)
+	if err != nil {
+		respondFilePathError(c, err)
+		return
+	}
+
+	var contextsByJob []string
+	result := h.db.Table("job_files").
+		Select("COALESCE(job_files.contexts, '')").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ? AND job_files.deleted_at IS NULL", id).
+		Where("job_files.name = ?", file).
+		Order("job_files.id").
+		Scan(&contextsByJob)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// Jobs of a build may each run part of the tests of a file
+	lineTests := make(map[int]map[string]bool)
+	for _, raw := range contextsByJob {
+		if raw == "" {
+			continue
+		}
+		var contexts map[string][]string
+		if err := json.Unmarshal([]byte(raw), &contexts); err != nil {
+			continue
+		}
+		for key, labels := range contexts {
+			line, err := strconv.Atoi(key)
+			if err != nil {
+				continue
+			}
+			if lineTests[line] == nil {
+				lineTests[line] = make(map[string]bool)
+			}
+			for _, label := range labels {
+				lineTests[line][label] = true
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, LineContexts{
+		BuildID: uint(id),
+		Path:    path,
+		File:    file,
+		Lines:   c.Query("lines"),
+		Tests:   testsForLines(lineTests, ranges),
+	})
+}
+
+// testsForLines inverts line -> tests into the tests executing any of the
+// given line ranges, sorted by name. No ranges means every line.
+func testsForLines(lineTests map[int]map[string]bool, ranges [][2]int) []TestContext {
+	byTest := make(map[string][]int)
+	for line, tests := range lineTests {
+		if !lineInRanges(line, ranges) {
+			continue
+		}
+		for test := range tests {
+			byTest[test] = append(byTest[test], line)
+		}
+	}
+
+	result := make([]TestContext, 0, len(byTest))
+	for name, lines := range byTest {
+		sort.Ints(lines)
+		result = append(result, TestContext{Name: name, Lines: lines})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// lineInRanges tells whether a line falls in one of the inclusive ranges, or whether there are no ranges
+func lineInRanges(line int, ranges [][2]int) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if line >= r[0] && line <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLineRanges parses comma separated lines and inclusive ranges such as "40-55,60"
+func parseLineRanges(value string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		startText, endText, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(startText)
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("invalid line range %q", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(endText)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid line range %q", part)
+			}
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
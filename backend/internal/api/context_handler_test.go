@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestLineContexts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Contexts", Token: "contexts-token"}
+	db.Create(&project)
+
+	coverageData := map[string]interface{}{
+		"repo_token": "contexts-token",
+		"source_files": []map[string]interface{}{
+			{
+				"name":     "src/pkg/parser.go",
+				"coverage": []interface{}{1, 2, 1, 0},
+				"contexts": map[string][]string{
+					"1": {"TestParse"},
+					"2": {"TestParse", "TestParseEmpty"},
+					"3": {"TestLex"},
+				},
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(coverageData)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/v2", NewJobHandler(db).Upload)
+	router.GET("/builds/:id/contexts", NewBuildHandler(db).Contexts)
+
+	req := httptest.NewRequest("POST", "/upload/v2", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var build models.Build
+	db.First(&build)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/contexts?path=pkg/parser.go&lines=2-4", build.ID), nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result LineContexts
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.File != "src/pkg/parser.go" || len(result.Tests) != 3 {
+		t.Fatalf("Expected 3 tests on src/pkg/parser.go, got %+v", result)
+	}
+	if result.Tests[0].Name != "TestLex" || result.Tests[1].Name != "TestParse" || len(result.Tests[1].Lines) != 1 || result.Tests[1].Lines[0] != 2 {
+		t.Errorf("Unexpected tests %+v", result.Tests)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/contexts?path=pkg/parser.go&lines=5-3", build.ID), nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an inverted range, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestLineContextsPathResolution(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Context paths", Token: "context-paths-token"}
+	db.Create(&project)
+	build := createTestBuild(t, db, project.ID, 1, "main", "sha", map[string]string{
+		"vendor/x/parser.go": `[1]`,
+		"lib/parser.go":      `[1]`,
+		"parser.go":          `[1]`,
+	})
+	for name, test := range map[string]string{"vendor/x/parser.go": "TestVendor", "lib/parser.go": "TestLib", "parser.go": "TestRoot"} {
+		db.Model(&models.JobFile{}).Where("name = ?", name).Update("contexts", `{"1":["`+test+`"]}`)
+	}
+
+	router := gin.New()
+	router.GET("/builds/:id/contexts", NewBuildHandler(db).Contexts)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/contexts?path=parser.go", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var result LineContexts
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.File != "parser.go" || len(result.Tests) != 1 || result.Tests[0].Name != "TestRoot" {
+		t.Errorf("Expected the tests of the exact match parser.go, got %+v", result)
+	}
+
+	db.Where("name = ?", "parser.go").Delete(&models.JobFile{})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/contexts?path=parser.go", build.ID), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an ambiguous path, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/contexts?path=%%25.go", build.ID), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a wildcard path, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestParseLineRanges(t *testing.T) {
+	ranges, err := parseLineRanges("40-55, 60")
+	if err != nil {
+		t.Fatalf("Failed to parse ranges: %v", err)
+	}
+	if len(ranges) != 2 || ranges[0] != [2]int{40, 55} || ranges[1] != [2]int{60, 60} {
+		t.Errorf("Unexpected ranges %v", ranges)
+	}
+	if _, err := parseLineRanges("a-b"); err == nil {
+		t.Error("Expected an error for a non-numeric range")
+	}
+}
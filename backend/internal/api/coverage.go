@@ -0,0 +1,300 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// lineNotRelevant marks a line that carries no coverage information
+// (a null entry in the Coveralls coverage array).
+const lineNotRelevant = -1
+
+// parseLineHits decodes a JobFile.Coverage JSON array into per-line hit counts.
+// Index 0 is line 1; lines that are not relevant hold lineNotRelevant.
+func parseLineHits(coverage string) []int {
+	var raw []interface{}
+	if coverage == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(coverage), &raw); err != nil {
+		return nil
+	}
+
+	hits := make([]int, len(raw))
+	for i, v := range raw {
+		hits[i] = lineNotRelevant
+		if val, ok := v.(float64); ok {
+			hits[i] = int(val)
+		}
+	}
+	return hits
+}
+
+// mergeLineHits combines the hit counts of the same file reported by several jobs.
+func mergeLineHits(a, b []int) []int {
+	if len(b) > len(a) {
+		a, b = b, a
+	}
+	merged := make([]int, len(a))
+	copy(merged, a)
+	for i, v := range b {
+		if v == lineNotRelevant {
+			continue
+		}
+		if merged[i] == lineNotRelevant {
+			merged[i] = v
+		} else {
+			merged[i] += v
+		}
+	}
+	return merged
+}
+
+// fileCoverage is the coverage of a single file, merged across the jobs of a build
+type fileCoverage struct {
+	Name     string
+	Source   string
+	Lines    []int
+	Branches []int // Flattened [line, block, branch, hits] quadruples
+}
+
+// counts returns the number of relevant and covered lines
+func (f *fileCoverage) counts() (relevant, covered int) {
+	for _, v := range f.Lines {
+		if v == lineNotRelevant {
+			continue
+		}
+		relevant++
+		if v > 0 {
+			covered++
+		}
+	}
+	return relevant, covered
+}
+
+// rate returns the line coverage percentage of the file
+func (f *fileCoverage) rate() float64 {
+	relevant, covered := f.counts()
+	return coverageRate(covered, relevant)
+}
+
+// hitsAt returns the hit count of a 1-based line number
+func (f *fileCoverage) hitsAt(line int) int {
+	if line < 1 || line > len(f.Lines) {
+		return lineNotRelevant
+	}
+	return f.Lines[line-1]
+}
+
+// branchCounts returns the number of branches and covered branches
+func (f *fileCoverage) branchCounts() (total, covered int) {
+	return countBranches(f.Branches)
+}
+
+// lineBranches returns the number of branches and covered branches of each line that has branches
+func (f *fileCoverage) lineBranches() map[int][2]int {
+	lines := make(map[int][2]int)
+	for i := 0; i+3 < len(f.Branches); i += 4 {
+		counts := lines[f.Branches[i]]
+		counts[0]++
+		if f.Branches[i+3] > 0 {
+			counts[1]++
+		}
+		lines[f.Branches[i]] = counts
+	}
+	return lines
+}
+
+// countBranches counts the branches of a flattened [line, block, branch, hits] array
+func countBranches(branches []int) (total, covered int) {
+	for i := 0; i+3 < len(branches); i += 4 {
+		total++
+		if branches[i+3] > 0 {
+			covered++
+		}
+	}
+	return total, covered
+}
+
+// parseBranches decodes a JobFile.Branches JSON array
+func parseBranches(branches string) []int {
+	var parsed []int
+	if branches == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(branches), &parsed); err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// mergeBranches combines the branch hits of the same file reported by several jobs
+func mergeBranches(a, b []int) []int {
+	merged := append([]int(nil), a...)
+	index := make(map[[3]int]int, len(a)/4)
+	for i := 0; i+3 < len(merged); i += 4 {
+		index[[3]int{merged[i], merged[i+1], merged[i+2]}] = i
+	}
+	for i := 0; i+3 < len(b); i += 4 {
+		key := [3]int{b[i], b[i+1], b[i+2]}
+		if j, ok := index[key]; ok {
+			merged[j+3] += b[i+3]
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, b[i:i+4]...)
+	}
+	return merged
+}
+
+// coverageRate returns covered/total as a percentage, or 0 when there is nothing to cover
+func coverageRate(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return (float64(covered) / float64(total)) * 100
+}
+
+// loadBuildFiles loads every file of a build, merging files reported by several jobs
+func loadBuildFiles(db *gorm.DB, buildID uint) (map[string]*fileCoverage, error) {
+	return queryBuildFiles(db.Select("job_files.*"), buildID)
+}
+
+// loadBuildCoverage is like loadBuildFiles but skips file sources
+func loadBuildCoverage(db *gorm.DB, buildID uint) (map[string]*fileCoverage, error) {
+	return queryBuildFiles(db.Select("job_files.name, job_files.coverage, job_files.branches"), buildID)
+}
+
+// queryBuildFiles loads the files of the jobs of a build with the given query
+func queryBuildFiles(query *gorm.DB, buildID uint) (map[string]*fileCoverage, error) {
+	var jobFiles []models.JobFile
+	if err := query.Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ?", buildID).
+		Order("job_files.id").
+		Find(&jobFiles).Error; err != nil {
+		return nil, err
+	}
+	return mergeJobFiles(jobFiles), nil
+}
+
+// loadJobFiles loads every file of a single job
+func loadJobFiles(db *gorm.DB, jobID uint) (map[string]*fileCoverage, error) {
+	return queryJobFiles(db, jobID)
+}
+
+// loadJobCoverage is like loadJobFiles but skips file sources
+func loadJobCoverage(db *gorm.DB, jobID uint) (map[string]*fileCoverage, error) {
+	return queryJobFiles(db.Select("name, coverage, branches"), jobID)
+}
+
+// queryJobFiles loads the files of a job with the given query
+func queryJobFiles(query *gorm.DB, jobID uint) (map[string]*fileCoverage, error) {
+	var jobFiles []models.JobFile
+	if err := query.Where("job_id = ?", jobID).Order("id").Find(&jobFiles).Error; err != nil {
+		return nil, err
+	}
+	return mergeJobFiles(jobFiles), nil
+}
+
+// mergeJobFiles groups job files by name and merges their line hits
+func mergeJobFiles(jobFiles []models.JobFile) map[string]*fileCoverage {
+	files := make(map[string]*fileCoverage, len(jobFiles))
+	for _, jf := range jobFiles {
+		lines := parseLineHits(jf.Coverage)
+		branches := parseBranches(jf.Branches)
+		if existing, ok := files[jf.Name]; ok {
+			existing.Lines = mergeLineHits(existing.Lines, lines)
+			existing.Branches = mergeBranches(existing.Branches, branches)
+			if existing.Source == "" {
+				existing.Source = jf.Source
+			}
+			continue
+		}
+		files[jf.Name] = &fileCoverage{Name: jf.Name, Source: jf.Source, Lines: lines, Branches: branches}
+	}
+	return files
+}
+
+// sortedFileNames returns the file names of a coverage map in lexical order
+func sortedFileNames(files map[string]*fileCoverage) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileSnapshot is the coverage of a file in one build
+type fileSnapshot struct {
+	Digest string
+	Lines  []int
+}
+
+// loadFileSnapshots loads the coverage of every file of the given builds,
+// without sources. Each file maps to one snapshot per build, in the order of
+// the builds, nil where the build did not report the file.
+func loadFileSnapshots(db *gorm.DB, builds []models.Build) (map[string][]*fileSnapshot, error) {
+	snapshots := make(map[string][]*fileSnapshot)
+	if len(builds) == 0 {
+		return snapshots, nil
+	}
+
+	buildIDs := make([]uint, len(builds))
+	order := make(map[uint]int, len(builds))
+	for i, b := range builds {
+		buildIDs[i] = b.ID
+		order[b.ID] = i
+	}
+
+	var rows []struct {
+		BuildID      uint
+		Name         string
+		Coverage     string
+		SourceDigest string
+	}
+	if err := db.Table("job_files").
+		Select("jobs.build_id, job_files.name, job_files.coverage, job_files.source_digest").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id IN ? AND job_files.deleted_at IS NULL", buildIDs).
+		Order("job_files.id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		perBuild, ok := snapshots[r.Name]
+		if !ok {
+			perBuild = make([]*fileSnapshot, len(builds))
+			snapshots[r.Name] = perBuild
+		}
+		i := order[r.BuildID]
+		lines := parseLineHits(r.Coverage)
+		if perBuild[i] == nil {
+			perBuild[i] = &fileSnapshot{Digest: r.SourceDigest, Lines: lines}
+		} else {
+			perBuild[i].Lines = mergeLineHits(perBuild[i].Lines, lines)
+		}
+	}
+	return snapshots, nil
+}
+
+// sameFileVersion tells whether two snapshots come from the same file source.
+// Files uploaded without source or digest are compared by their relevant lines.
+func sameFileVersion(a, b *fileSnapshot) bool {
+	if a.Digest != "" && b.Digest != "" {
+		return a.Digest == b.Digest
+	}
+	if a.Digest != b.Digest || len(a.Lines) != len(b.Lines) {
+		return false
+	}
+	for i := range a.Lines {
+		if (a.Lines[i] == lineNotRelevant) != (b.Lines[i] == lineNotRelevant) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ExportHandler exports stored coverage in the formats of other tools
+type ExportHandler struct {
+	db *gorm.DB
+}
+
+// NewExportHandler creates a new export handler
+func NewExportHandler(db *gorm.DB) *ExportHandler {
+	return &ExportHandler{db: db}
+}
+
+// coverageExport is the merged coverage of a build, or of one of its jobs, to export
+type coverageExport struct {
+	Project   models.Project
+	Build     models.Build
+	Job       *models.Job // Only set when exporting a single job
+	Files     map[string]*fileCoverage
+	Functions map[string][]FunctionCoverage // By file name
+
+	// SourceRoot is the directory of the repository checkout where the coverage
+	// was collected, for formats that need file names relative to the repository
+	SourceRoot string
+
+	db *gorm.DB
+}
+
+// exportFormat writes coverage in the format of another tool
+type exportFormat struct {
+	ContentType string
+	FileName    string // Name of the downloaded file, as expected by the tools that read it
+	WithSource  bool   // Load file sources
+	Write       func(w io.Writer, export *coverageExport) error
+}
+
+// exportFormats are the formats of the export endpoints, by name
+var exportFormats = map[string]exportFormat{
+	"lcov":      {ContentType: "text/plain; charset=utf-8", FileName: "lcov.info", Write: writeLCOV},
+	"cobertura": {ContentType: "application/xml; charset=utf-8", FileName: "cobertura.xml", Write: writeCobertura},
+	"sonarqube": {ContentType: "application/xml; charset=utf-8", FileName: "sonar-coverage.xml", Write: writeSonarQube},
+	"sarif":     {ContentType: "application/sarif+json", FileName: "coverage.sarif", Write: writeSARIF},
+	"html":      {ContentType: "application/zip", FileName: "coverage-report.zip", WithSource: true, Write: writeHTMLReport},
+}
+
+// exportFormatNames lists the supported export formats
+func exportFormatNames() string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// ExportBuild exports the merged coverage of every job of a build
+//
+//	@Summary		Export build coverage
+//	@Description	Download the merged coverage of a build in the format of another tool: lcov (lcov.info tracefile), cobertura (Cobertura XML), sonarqube (SonarQube generic test coverage), sarif (uncovered lines as SARIF 2.1.0 results) or html (zipped static HTML report)
+//	@Tags			export
+//	@Produce		plain
+//	@Param			id		path		string	true	"Build ID"
+//	@Param			format	path		string	true	"Export format"
+//	@Param			source_root	query		string	false	"Checkout directory stripped from absolute file names (sarif)"
+//	@Success		200		{file}		file
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/export/{format} [get]
+func (h *ExportHandler) ExportBuild(c *gin.Context) {
+	format, ok := exportFormatParam(c)
+	if !ok {
+		return
+	}
+
+	var build models.Build
+	if err := h.db.First(&build, "id = ?", c.Param("id")).Error; err != nil {
+		respondBuildLookupError(c, err, "Build not found")
+		return
+	}
+
+	h.export(c, format, build, nil)
+}
+
+// ExportJob exports the coverage of a single job
+//
+//	@Summary		Export job coverage
+//	@Description	Download the coverage of one job in the format of another tool: lcov (lcov.info tracefile), cobertura (Cobertura XML), sonarqube (SonarQube generic test coverage), sarif (uncovered lines as SARIF 2.1.0 results) or html (zipped static HTML report)
+//	@Tags			export
+//	@Produce		plain
+//	@Param			id		path		string	true	"Job ID"
+//	@Param			format	path		string	true	"Export format"
+//	@Param			source_root	query		string	false	"Checkout directory stripped from absolute file names (sarif)"
+//	@Success		200		{file}		file
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/jobs/{id}/export/{format} [get]
+func (h *ExportHandler) ExportJob(c *gin.Context) {
+	format, ok := exportFormatParam(c)
+	if !ok {
+		return
+	}
+
+	var job models.Job
+	if err := h.db.Preload("Build").First(&job, "id = ?", c.Param("id")).Error; err != nil {
+		respondBuildLookupError(c, err, "Job not found")
+		return
+	}
+
+	h.export(c, format, job.Build, &job)
+}
+
+// ExportLatest exports the merged coverage of the latest build of a branch
+//
+//	@Summary		Export latest coverage
+//	@Description	Download the merged coverage of the latest build of a branch in the format of another tool: lcov (lcov.info tracefile), cobertura (Cobertura XML), sonarqube (SonarQube generic test coverage), sarif (uncovered lines as SARIF 2.1.0 results) or html (zipped static HTML report)
+//	@Tags			export
+//	@Produce		plain
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			format	path		string	true	"Export format"
+//	@Param			source_root	query		string	false	"Checkout directory stripped from absolute file names (sarif)"
+//	@Param			branch	query		string	false	"Branch (defaults to the project's current branch)"
+//	@Success		200		{file}		file
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/export/{format} [get]
+func (h *ExportHandler) ExportLatest(c *gin.Context) {
+	format, ok := exportFormatParam(c)
+	if !ok {
+		return
+	}
+
+	var project models.Project
+	if err := h.db.Where("id = ?", c.Param("id")).First(&project).Error; err != nil {
+		respondBuildLookupError(c, err, "Project not found")
+		return
+	}
+
+	query := h.db.Where("project_id = ?", project.ID)
+	if branch := c.DefaultQuery("branch", project.CurrentBranch); branch != "" {
+		query = query.Where("branch = ?", branch)
+	}
+	var build models.Build
+	if err := query.Order("build_num DESC").First(&build).Error; err != nil {
+		respondBuildLookupError(c, err, "No build found for this branch")
+		return
+	}
+
+	h.export(c, format, build, nil)
+}
+
+// exportFormatParam resolves the format path parameter. It writes the error
+// response and returns false for unknown formats.
+func exportFormatParam(c *gin.Context) (exportFormat, bool) {
+	format, ok := exportFormats[c.Param("format")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown export format, expected one of: " + exportFormatNames()})
+	}
+	return format, ok
+}
+
+// export loads the coverage of a build, or of one of its jobs, and sends it in an export format
+func (h *ExportHandler) export(c *gin.Context, format exportFormat, build models.Build, job *models.Job) {
+	export := &coverageExport{Build: build, Job: job, SourceRoot: c.Query("source_root"), db: h.db}
+
+	if err := h.db.Where("id = ?", build.ProjectID).First(&export.Project).Error; err != nil {
+		respondBuildLookupError(c, err, "Project not found")
+		return
+	}
+
+	var err error
+	var functions []FunctionCoverage
+	switch {
+	case job != nil && format.WithSource:
+		export.Files, err = loadJobFiles(h.db, job.ID)
+	case job != nil:
+		export.Files, err = loadJobCoverage(h.db, job.ID)
+	case format.WithSource:
+		export.Files, err = loadBuildFiles(h.db, build.ID)
+	default:
+		export.Files, err = loadBuildCoverage(h.db, build.ID)
+	}
+	if err == nil {
+		if job != nil {
+			functions, err = loadJobFunctions(h.db, job.ID)
+		} else {
+			functions, err = loadBuildFunctions(h.db, build.ID)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coverage"})
+		return
+	}
+
+	export.Functions = make(map[string][]FunctionCoverage)
+	for _, fn := range functions {
+		export.Functions[fn.File] = append(export.Functions[fn.File], fn)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Write(&buf, export); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export coverage", "details": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, format.FileName))
+	c.Data(http.StatusOK, format.ContentType, buf.Bytes())
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// setupExportTest uploads a build with line, branch and function coverage to export
+func setupExportTest(t *testing.T) (*gin.Engine, *gorm.DB, models.Project, models.Build, models.Job) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Export", Token: "export-token", CurrentBranch: "main"}
+	db.Create(&project)
+
+	router := gin.New()
+	router.POST("/upload/v2", NewJobHandler(db).Upload)
+	exportHandler := NewExportHandler(db)
+	router.GET("/builds/:id/export/:format", exportHandler.ExportBuild)
+	router.GET("/jobs/:id/export/:format", exportHandler.ExportJob)
+	router.GET("/projects/:id/export/:format", exportHandler.ExportLatest)
+
+	upload := map[string]interface{}{
+		"repo_token":     "export-token",
+		"flag_name":      "unit",
+		"service_job_id": "1",
+		"git":            map[string]interface{}{"branch": "main", "head": map[string]interface{}{"id": "abc123"}},
+		"source_files": []map[string]interface{}{
+			{
+				"name":     "pkg/b.go",
+				"source":   "func Run() {}\n\nif a < b { stop() }\n",
+				"coverage": []interface{}{1, nil, 0},
+				"branches": []int{3, 0, 0, 0, 3, 0, 1, 0},
+				"functions": []map[string]interface{}{
+					{"name": "Run", "start_line": 1, "end_line": 1, "hits": 1},
+					{"name": "stop", "start_line": 3, "end_line": 3, "hits": 0},
+				},
+			},
+			{"name": "a.go", "coverage": []interface{}{2}},
+		},
+	}
+	body, _ := json.Marshal(upload)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/upload/v2", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to upload coverage: %d %s", w.Code, w.Body.String())
+	}
+
+	var build models.Build
+	db.First(&build)
+	var job models.Job
+	db.First(&job)
+
+	return router, db, project, build, job
+}
+
+func TestExportLCOV(t *testing.T) {
+	router, _, project, build, job := setupExportTest(t)
+
+	expected := strings.Join([]string{
+		"TN:", "SF:a.go", "FNF:0", "FNH:0", "BRF:0", "BRH:0", "DA:1,2", "LF:1", "LH:1", "end_of_record",
+		"TN:", "SF:pkg/b.go",
+		"FN:1,Run", "FN:3,stop", "FNDA:1,Run", "FNDA:0,stop", "FNF:2", "FNH:1",
+		"BRDA:3,0,0,-", "BRDA:3,0,1,-", "BRF:2", "BRH:0",
+		"DA:1,1", "DA:3,0", "LF:2", "LH:1", "end_of_record",
+	}, "\n") + "\n"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/lcov", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w.Body.String() != expected {
+		t.Errorf("Unexpected lcov export:\n%s", w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="lcov.info"` {
+		t.Errorf("Expected an lcov.info attachment, got %q", got)
+	}
+
+	// A single job names the test after its flag
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/jobs/%d/export/lcov", job.ID), nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "TN:unit\nSF:a.go\n") {
+		t.Errorf("Expected the job export to be named after its flag, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The latest build of the current branch
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/projects/%s/export/lcov", project.ID), nil))
+	if w.Code != http.StatusOK || w.Body.String() != expected {
+		t.Errorf("Expected the latest build to be exported, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/projects/%s/export/lcov?branch=feature", project.ID), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a branch without builds, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/unknown", build.ID), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestExportCobertura(t *testing.T) {
+	router, _, _, build, _ := setupExportTest(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/cobertura", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="cobertura.xml"` {
+		t.Errorf("Expected a cobertura.xml attachment, got %q", got)
+	}
+
+	var report coberturaCoverage
+	if err := xml.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse the Cobertura report: %v\n%s", err, w.Body.String())
+	}
+	if report.LinesValid != 3 || report.LinesCovered != 2 || report.BranchesValid != 2 || report.BranchesCovered != 0 {
+		t.Errorf("Unexpected totals: %+v", report)
+	}
+	if len(report.Packages) != 2 || report.Packages[0].Name != "." || report.Packages[1].Name != "pkg" {
+		t.Fatalf("Expected a package per directory, got %+v", report.Packages)
+	}
+
+	class := report.Packages[1].Classes[0]
+	if class.Filename != "pkg/b.go" || class.Name != "b" || class.LineRate != "0.5000" {
+		t.Errorf("Unexpected class: %+v", class)
+	}
+	if len(class.Lines) != 2 || class.Lines[1].Number != 3 || !class.Lines[1].Branch || class.Lines[1].ConditionCoverage != "0% (0/2)" {
+		t.Errorf("Unexpected lines: %+v", class.Lines)
+	}
+	if len(class.Methods) != 2 || class.Methods[0].Name != "Run" || class.Methods[0].LineRate != "1.0000" || class.Methods[1].LineRate != "0.0000" || class.Methods[1].BranchRate != "0.0000" {
+		t.Errorf("Unexpected methods: %+v", class.Methods)
+	}
+}
+
+func TestCoberturaRate(t *testing.T) {
+	// Small ratios are plain decimals, never in scientific notation
+	for _, tt := range []struct {
+		covered, total int
+		expected       string
+	}{{1, 100000, "0.0000"}, {1, 3, "0.3333"}, {3, 3, "1.0000"}, {0, 0, "1.0000"}} {
+		if got := coberturaRate(tt.covered, tt.total); got != tt.expected {
+			t.Errorf("coberturaRate(%d, %d) = %q, expected %q", tt.covered, tt.total, got, tt.expected)
+		}
+	}
+}
+
+func TestExportSonarQube(t *testing.T) {
+	router, _, _, build, _ := setupExportTest(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/sonarqube", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	expected := `<coverage version="1">
+  <file path="a.go">
+    <lineToCover lineNumber="1" covered="true"></lineToCover>
+  </file>
+  <file path="pkg/b.go">
+    <lineToCover lineNumber="1" covered="true"></lineToCover>
+    <lineToCover lineNumber="3" covered="false" branchesToCover="2" coveredBranches="0"></lineToCover>
+  </file>
+</coverage>
+`
+	if w.Body.String() != expected {
+		t.Errorf("Unexpected SonarQube export:\n%s", w.Body.String())
+	}
+}
+
+func TestExportSARIF(t *testing.T) {
+	router, db, project, build, _ := setupExportTest(t)
+
+	export := func() sarifRun {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/sarif", build.ID), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var log sarifLog
+		if err := json.Unmarshal(w.Body.Bytes(), &log); err != nil || log.Version != "2.1.0" || len(log.Runs) != 1 {
+			t.Fatalf("Expected a SARIF 2.1.0 log with one run, got %s", w.Body.String())
+		}
+		return log.Runs[0]
+	}
+
+	// Every uncovered line is a note without a quality gate
+	run := export()
+	if len(run.Results) != 1 {
+		t.Fatalf("Expected one result, got %+v", run.Results)
+	}
+	result := run.Results[0]
+	location := result.Locations[0].PhysicalLocation
+	if result.RuleID != "uncovered-line" || result.Level != "note" || location.ArtifactLocation.URI != "pkg/b.go" || location.Region.StartLine != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if run.OriginalURIBaseIDs != nil {
+		t.Errorf("Expected no source root without the source_root parameter, got %v", run.OriginalURIBaseIDs)
+	}
+
+	// The source root of the checkout is reported for %SRCROOT%
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/sarif?source_root=/home/runner/work/x", build.ID), nil))
+	var log sarifLog
+	json.Unmarshal(w.Body.Bytes(), &log)
+	if len(log.Runs) != 1 || log.Runs[0].OriginalURIBaseIDs[sarifSourceRoot].URI != "file:///home/runner/work/x/" {
+		t.Errorf("Expected the source root in originalUriBaseIds, got %s", w.Body.String())
+	}
+
+	// The path rule of the file drives the level
+	minCoverage := 10.0
+	gate := models.QualityGate{ProjectID: project.ID, MinCoverage: &minCoverage, PathRules: []models.QualityGatePathRule{{Path: "pkg/", MinCoverage: 60}}}
+	db.Create(&gate)
+	if run = export(); run.Results[0].Level != "error" {
+		t.Errorf("Expected an error below the path rule minimum, got %q", run.Results[0].Level)
+	}
+	db.Model(&gate.PathRules[0]).Update("min_coverage", 40)
+	if run = export(); run.Results[0].Level != "warning" {
+		t.Errorf("Expected a warning above the path rule minimum, got %q", run.Results[0].Level)
+	}
+
+	// With a patch, only uncovered changed lines are reported, leveled by the minimum patch coverage
+	db.Model(&build).Updates(map[string]interface{}{"changed_lines": `{"pkg/b.go":[1,3],"a.go":[1]}`, "base_commit_sha": "base123"})
+	db.Model(&gate).Update("min_patch_coverage", 90)
+	run = export()
+	if len(run.Results) != 1 || run.Results[0].RuleID != "uncovered-changed-line" || run.Results[0].Level != "error" ||
+		run.Results[0].Locations[0].PhysicalLocation.Region.StartLine != 3 {
+		t.Errorf("Unexpected patch results: %+v", run.Results)
+	}
+	if run.Properties["baseCommitSha"] != "base123" {
+		t.Errorf("Expected the base commit in the run properties, got %v", run.Properties)
+	}
+}
+
+func TestSARIFPathLevels(t *testing.T) {
+	files := map[string]*fileCoverage{
+		"src/a.go":    {Name: "src/a.go", Lines: []int{0, 0}},
+		"srcfoo/b.go": {Name: "srcfoo/b.go", Lines: []int{1, 1}},
+	}
+	gate := &models.QualityGate{PathRules: []models.QualityGatePathRule{{Path: "src", MinCoverage: 50}}}
+
+	// Rules match directories, not siblings sharing their prefix
+	levels := sarifPathLevels(gate, files)
+	if got := levels("src/a.go"); got != sarifLevelError {
+		t.Errorf("Expected an error under the rule, got %q", got)
+	}
+	if got := levels("srcfoo/b.go"); got != sarifLevelNote {
+		t.Errorf("Expected a note outside the rule, got %q", got)
+	}
+}
+
+func TestSARIFArtifactLocation(t *testing.T) {
+	tests := []struct {
+		name, sourceRoot string
+		expected         sarifLocationURI
+	}{
+		{"pkg/b.go", "", sarifLocationURI{URI: "pkg/b.go", URIBaseID: sarifSourceRoot}},
+		{"./pkg/my file.go", "", sarifLocationURI{URI: "pkg/my%20file.go", URIBaseID: sarifSourceRoot}},
+		{"/home/runner/work/x/pkg/b.go", "/home/runner/work/x/", sarifLocationURI{URI: "pkg/b.go", URIBaseID: sarifSourceRoot}},
+		{"/home/runner/work/x/pkg/b.go", "", sarifLocationURI{URI: "file:///home/runner/work/x/pkg/b.go"}},
+		{"/home/runner/work/xy/b.go", "/home/runner/work/x", sarifLocationURI{URI: "file:///home/runner/work/xy/b.go"}},
+	}
+	for _, tt := range tests {
+		if got := sarifArtifactLocation(tt.name, tt.sourceRoot); got != tt.expected {
+			t.Errorf("sarifArtifactLocation(%q, %q) = %+v, expected %+v", tt.name, tt.sourceRoot, got, tt.expected)
+		}
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	router, _, _, build, _ := setupExportTest(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/export/html", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open the report archive: %v", err)
+	}
+	pages := make(map[string]string)
+	for _, f := range archive.File {
+		r, _ := f.Open()
+		content, _ := io.ReadAll(r)
+		r.Close()
+		pages[f.Name] = string(content)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("Expected an index and two file pages, got %v", len(pages))
+	}
+
+	index := pages["coverage-report/index.html"]
+	for _, want := range []string{`<a href="files/a.go.html">a.go</a>`, `pkg/</td>`, `<a href="files/pkg/b.go.html">b.go</a>`, `<td class="num medium">66.67%</td>`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("Expected the index to contain %q:\n%s", want, index)
+		}
+	}
+
+	// The report must not load anything from a server
+	if strings.Contains(index, `src="http`) || strings.Contains(index, `href="http`) {
+		t.Errorf("Expected no external resources in the index")
+	}
+
+	page := pages["coverage-report/files/pkg/b.go.html"]
+	for _, want := range []string{
+		`<a href="../../index.html">`,
+		`<tr id="L1" class="covered"><td class="line">1</td><td class="hits">1</td><td class="branches"></td><td class="code">func Run() {}</td></tr>`,
+		`<tr id="L2"><td class="line">2</td><td class="hits"></td>`,
+		`<tr id="L3" class="uncovered"><td class="line">3</td><td class="hits">0</td><td class="branches">0/2</td><td class="code">if a &lt; b { stop() }</td></tr>`,
+		"1 of 2 functions",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the file page to contain %q:\n%s", want, page)
+		}
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	first := models.Project{Name: "First", Token: "history-first"}
+	second := models.Project{Name: "Second", Token: "history-second"}
+	db.Create(&first)
+	db.Create(&second)
+
+	build := createTestBuild(t, db, first.ID, 1, "main", "aaa", map[string]string{"a.go": "[1,0]", "b.go": "[1,1]"})
+	db.Model(&build).Update("created_at", time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC))
+	branchRate := 50.0
+	db.Model(&models.Job{}).Where("build_id = ?", build.ID).Updates(models.Job{FlagName: "unit", BranchCoverageRate: &branchRate})
+	build = createTestBuild(t, db, first.ID, 2, "main", "bbb", map[string]string{"a.go": "[1,1]"})
+	db.Model(&build).Update("created_at", time.Date(2025, 2, 10, 9, 0, 0, 0, time.UTC))
+	build = createTestBuild(t, db, second.ID, 1, "dev", "ccc", map[string]string{"c.go": "[0]"})
+	db.Model(&build).Update("created_at", time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC))
+
+	router := gin.New()
+	exportHandler := NewExportHandler(db)
+	router.GET("/projects/:id/history/export", exportHandler.ExportHistory)
+	router.GET("/admin/history/export", exportHandler.ExportHistory)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+	records := func(w *httptest.ResponseRecorder) [][]string {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to parse CSV: %v", err)
+		}
+		return records
+	}
+
+	// The builds of one project, in date order
+	w := get(fmt.Sprintf("/projects/%s/history/export", first.ID))
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="builds.csv"` {
+		t.Errorf("Expected a builds.csv attachment, got %q", got)
+	}
+	builds := records(w)
+	if len(builds) != 3 || strings.Join(builds[0][:7], ",") != "project_id,project_name,build_id,build_num,branch,commit_sha,created_at" {
+		t.Fatalf("Unexpected builds export: %v", builds)
+	}
+	if builds[1][5] != "aaa" || builds[1][6] != "2025-01-10T09:00:00Z" || builds[1][7] != "75" || builds[2][5] != "bbb" || builds[2][7] != "100" {
+		t.Errorf("Unexpected builds: %v", builds[1:])
+	}
+
+	// Every project over a date range, with an inclusive end date
+	builds = records(get("/admin/history/export?from=2025-01-15&to=2025-02-10"))
+	if len(builds) != 3 || builds[1][1] != "Second" || builds[2][5] != "bbb" {
+		t.Errorf("Unexpected builds between the dates: %v", builds)
+	}
+
+	// Jobs export empty optional rates as empty cells
+	jobs := records(get(fmt.Sprintf("/projects/%s/history/export?dataset=jobs", first.ID)))
+	if len(jobs) != 3 || jobs[0][9] != "flag_name" || jobs[1][9] != "unit" || jobs[1][11] != "50" || jobs[2][11] != "" {
+		t.Errorf("Unexpected jobs export: %v", jobs)
+	}
+
+	files := records(get("/admin/history/export?dataset=files&to=2025-01-31"))
+	if len(files) != 4 || files[1][9] != "a.go" || files[1][10] != "50" || files[3][9] != "c.go" {
+		t.Errorf("Unexpected files export: %v", files)
+	}
+
+	w = get(fmt.Sprintf("/projects/%s/history/export?format=parquet&dataset=files", first.ID))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "PAR1") || !strings.HasSuffix(w.Body.String(), "PAR1") {
+		t.Errorf("Expected a Parquet file, got %d: %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="files.parquet"` {
+		t.Errorf("Expected a files.parquet attachment, got %q", got)
+	}
+
+	// Null rates and dates of legacy rows do not abort the export
+	db.Model(&models.Build{}).Where("id = ?", build.ID).Updates(map[string]interface{}{"created_at": nil, "coverage_rate": nil})
+	w = get(fmt.Sprintf("/projects/%s/history/export?format=parquet", second.ID))
+	if w.Code != http.StatusOK || !strings.HasSuffix(w.Body.String(), "PAR1") {
+		t.Errorf("Expected a complete Parquet file with null values, got %d: %q", w.Code, w.Body.String())
+	}
+	builds = records(get(fmt.Sprintf("/projects/%s/history/export", second.ID)))
+	if len(builds) != 2 || builds[1][6] != "" || builds[1][7] != "" {
+		t.Errorf("Expected empty cells for null values, got %v", builds)
+	}
+
+	for _, url := range []string{"/admin/history/export?dataset=lines", "/admin/history/export?format=xlsx", "/admin/history/export?from=yesterday"} {
+		if w := get(url); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, url, w.Code)
+		}
+	}
+	if w := get("/projects/unknown/history/export"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown project, got %d", http.StatusNotFound, w.Code)
+	}
+}
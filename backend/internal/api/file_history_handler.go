@@ -0,0 +1,304 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FileHistoryEntry is the coverage of a file in one build
+type FileHistoryEntry struct {
+	BuildID             uint      `json:"build_id"`
+	BuildNum            int       `json:"build_num"`
+	CommitSHA           string    `json:"commit_sha"`
+	CreatedAt           time.Time `json:"created_at"`
+	Present             bool      `json:"present"` // false when the build did not report the file
+	RelevantLines       int       `json:"relevant_lines"`
+	CoveredLines        int       `json:"covered_lines"`
+	CoverageRate        float64   `json:"coverage_rate"`
+	UncoveredLines      []int     `json:"uncovered_lines"`
+	NewlyUncoveredLines []int     `json:"newly_uncovered_lines"` // Covered in the previous build that reported the file
+}
+
+// UncoveredSince tells from which build a currently uncovered line has been uncovered
+type UncoveredSince struct {
+	Line      int    `json:"line"`
+	BuildID   uint   `json:"build_id"`
+	BuildNum  int    `json:"build_num"`
+	CommitSHA string `json:"commit_sha"`
+}
+
+// FileHistory is the coverage of a file across the recent builds of a branch
+type FileHistory struct {
+	ProjectID      string             `json:"project_id"`
+	Branch         string             `json:"branch"`
+	Path           string             `json:"path"`
+	Builds         []FileHistoryEntry `json:"builds"`          // Oldest first
+	UncoveredSince []UncoveredSince   `json:"uncovered_since"` // Lines uncovered in the latest build
+}
+
+// History returns the line coverage of a file across recent builds of a branch
+//
+//	@Summary		File coverage history
+//	@Description	Get the coverage rate and uncovered lines of a file across the recent builds of a branch, flagging the build where each line became uncovered
+//	@Tags			files
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			path	query		string	true	"File path"
+//	@Param			branch	query		string	false	"Branch (defaults to the project's current branch)"
+//	@Param			limit	query		int		false	"Number of builds (default 20, max 200)"
+//	@Success		200		{object}	FileHistory
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/files/history [get]
+func (h *FileHandler) History(c *gin.Context) {
+	projectID := c.Param("id")
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+
+	limit := 20
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, 200)
+	}
+
+	var project models.Project
+	if err := h.db.Where("id = ?", projectID).First(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
+		}
+		return
+	}
+
+	branch := c.DefaultQuery("branch", project.CurrentBranch)
+
+	builds, err := recentBuilds(h.db, project.ID, branch, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch builds"})
+		return
+	}
+
+	buildIDs := make([]uint, len(builds))
+	for i, b := range builds {
+		buildIDs[i] = b.ID
+	}
+
+	name, err := resolveFilePath(h.db, buildIDs, path)
+	if err != nil {
+		respondFilePathError(c, err)
+		return
+	}
+
+	perBuild, err := loadFileAcrossBuilds(h.db, buildIDs, name, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, FileHistory{
+		ProjectID:      project.ID,
+		Branch:         branch,
+		Path:           path,
+		Builds:         buildFileHistory(builds, perBuild),
+		UncoveredSince: uncoveredSince(builds, perBuild),
+	})
+}
+
+// recentBuilds returns the latest builds of a branch, oldest first
+func recentBuilds(db *gorm.DB, projectID, branch string, limit int) ([]models.Build, error) {
+	var builds []models.Build
+	if err := db.Where("project_id = ? AND branch = ?", projectID, branch).
+		Order("build_num DESC").
+		Limit(limit).
+		Find(&builds).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(builds)-1; i < j; i, j = i+1, j-1 {
+		builds[i], builds[j] = builds[j], builds[i]
+	}
+	return builds, nil
+}
+
+// resolveFilePath resolves a requested path to the name of a file reported by
+// the given builds. Coverage tools do not always report paths relative to the
+// repository root, so when no file has exactly that name, a file whose name
+// ends with the path is accepted if it is the only one, see matchFilePath.
+// The path itself is returned when no file matches.
+func resolveFilePath(db *gorm.DB, buildIDs []uint, path string) (string, error) {
+	if len(buildIDs) == 0 {
+		return path, nil
+	}
+
+	var names []string
+	if err := db.Table("job_files").
+		Distinct("job_files.name").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id IN ? AND job_files.deleted_at IS NULL", buildIDs).
+		Where("job_files.name = ? OR job_files.name LIKE ? ESCAPE '\\'", path, "%/"+escapeLike(path)).
+		Order("job_files.name").
+		Pluck("job_files.name", &names).Error; err != nil {
+		return "", err
+	}
+
+	name, err := matchFilePath(names, path)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return path, nil
+	}
+	return name, nil
+}
+
+// respondFilePathError writes the error response for a failed file path resolution
+func respondFilePathError(c *gin.Context, err error) {
+	var ambiguous *ambiguousPathError
+	if errors.As(err, &ambiguous) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ambiguous path", "candidates": ambiguous.candidates})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+	}
+}
+
+// loadFileAcrossBuilds loads one file from each of the given builds, keyed by build ID.
+// The file is looked up by exact name, see resolveFilePath. Files are only
+// linked to builds through their jobs, and jobs of the same build are merged.
+// Sources are only loaded when asked for.
+func loadFileAcrossBuilds(db *gorm.DB, buildIDs []uint, path string, withSource bool) (map[uint]*fileCoverage, error) {
+	var rows []struct {
+		BuildID  uint
+		Name     string
+		Coverage string
+		Source   string
+	}
+	if len(buildIDs) == 0 {
+		return map[uint]*fileCoverage{}, nil
+	}
+	columns := "jobs.build_id, job_files.name, job_files.coverage"
+	if withSource {
+		columns += ", job_files.source"
+	}
+	if err := db.Table("job_files").
+		Select(columns).
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id IN ? AND job_files.deleted_at IS NULL", buildIDs).
+		Where("job_files.name = ?", path).
+		Order("job_files.id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	files := make(map[uint]*fileCoverage)
+	for _, r := range rows {
+		lines := parseLineHits(r.Coverage)
+		if existing, ok := files[r.BuildID]; ok {
+			existing.Lines = mergeLineHits(existing.Lines, lines)
+			continue
+		}
+		files[r.BuildID] = &fileCoverage{Name: r.Name, Source: r.Source, Lines: lines}
+	}
+	return files, nil
+}
+
+// uncoveredLines returns the relevant lines of a file that were not hit
+func uncoveredLines(file *fileCoverage) []int {
+	lines := []int{}
+	for i, hits := range file.Lines {
+		if hits == 0 {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// buildFileHistory builds the per-build history of a file
+func buildFileHistory(builds []models.Build, files map[uint]*fileCoverage) []FileHistoryEntry {
+	entries := make([]FileHistoryEntry, 0, len(builds))
+	var previous *fileCoverage
+
+	for _, b := range builds {
+		entry := FileHistoryEntry{
+			BuildID:             b.ID,
+			BuildNum:            b.BuildNum,
+			CommitSHA:           b.CommitSHA,
+			CreatedAt:           b.CreatedAt,
+			UncoveredLines:      []int{},
+			NewlyUncoveredLines: []int{},
+		}
+
+		if file, ok := files[b.ID]; ok {
+			entry.Present = true
+			entry.RelevantLines, entry.CoveredLines = file.counts()
+			entry.CoverageRate = file.rate()
+			entry.UncoveredLines = uncoveredLines(file)
+			if previous != nil {
+				for _, line := range entry.UncoveredLines {
+					if previous.hitsAt(line) > 0 {
+						entry.NewlyUncoveredLines = append(entry.NewlyUncoveredLines, line)
+					}
+				}
+			}
+			previous = file
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// uncoveredSince finds, for each line uncovered in the latest build reporting
+// the file, the first build of its current uncovered streak
+func uncoveredSince(builds []models.Build, files map[uint]*fileCoverage) []UncoveredSince {
+	result := []UncoveredSince{}
+
+	latest := -1
+	for i := len(builds) - 1; i >= 0; i-- {
+		if _, ok := files[builds[i].ID]; ok {
+			latest = i
+			break
+		}
+	}
+	if latest < 0 {
+		return result
+	}
+
+	for _, line := range uncoveredLines(files[builds[latest].ID]) {
+		since := builds[latest]
+		for i := latest - 1; i >= 0; i-- {
+			file, ok := files[builds[i].ID]
+			if !ok {
+				continue
+			}
+			if file.hitsAt(line) != 0 {
+				break
+			}
+			since = builds[i]
+		}
+		result = append(result, UncoveredSince{
+			Line:      line,
+			BuildID:   since.ID,
+			BuildNum:  since.BuildNum,
+			CommitSHA: since.CommitSHA,
+		})
+	}
+
+	return result
+}
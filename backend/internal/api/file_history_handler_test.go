@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestFileHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "History", Token: "history-token", CurrentBranch: "main"}
+	db.Create(&project)
+
+	createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{"src/pkg/a.go": `[1, 1, 1]`})
+	createTestBuild(t, db, project.ID, 2, "main", "c2", map[string]string{"src/pkg/a.go": `[1, 0, 1]`})
+	createTestBuild(t, db, project.ID, 3, "feature", "f1", map[string]string{"src/pkg/a.go": `[0, 0, 0]`})
+	createTestBuild(t, db, project.ID, 4, "main", "c3", map[string]string{"other.go": `[1]`})
+	createTestBuild(t, db, project.ID, 5, "main", "c4", map[string]string{"src/pkg/a.go": `[1, 0, 0]`})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/files/history", NewFileHandler(db).History)
+
+	req := httptest.NewRequest("GET", "/projects/"+project.ID+"/files/history?path=pkg/a.go", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var history FileHistory
+	json.Unmarshal(w.Body.Bytes(), &history)
+
+	if len(history.Builds) != 4 {
+		t.Fatalf("Expected the 4 main builds, got %d", len(history.Builds))
+	}
+	if history.Builds[2].Present {
+		t.Errorf("Expected build 4 not to report the file")
+	}
+	if !reflect.DeepEqual(history.Builds[1].NewlyUncoveredLines, []int{2}) {
+		t.Errorf("Expected line 2 newly uncovered in build 2, got %v", history.Builds[1].NewlyUncoveredLines)
+	}
+	if !reflect.DeepEqual(history.Builds[3].NewlyUncoveredLines, []int{3}) {
+		t.Errorf("Expected line 3 newly uncovered in build 5, got %v", history.Builds[3].NewlyUncoveredLines)
+	}
+
+	expected := []UncoveredSince{
+		{Line: 2, BuildID: history.Builds[1].BuildID, BuildNum: 2, CommitSHA: "c2"},
+		{Line: 3, BuildID: history.Builds[3].BuildID, BuildNum: 5, CommitSHA: "c4"},
+	}
+	if !reflect.DeepEqual(history.UncoveredSince, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, history.UncoveredSince)
+	}
+}
+
+func TestFileHistoryPathResolution(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Paths", Token: "paths-token", CurrentBranch: "main"}
+	db.Create(&project)
+
+	createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{
+		"main.go":       `[1, 1]`,
+		"cmd/a/main.go": `[0, 0]`,
+		"cmd/b/main.go": `[0, 1]`,
+		"pkg/xzy.go":    `[1]`,
+	})
+
+	router := gin.New()
+	router.GET("/projects/:id/files/history", NewFileHandler(db).History)
+	get := func(path string) (*httptest.ResponseRecorder, FileHistory) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/projects/"+project.ID+"/files/history?path="+path, nil))
+		var history FileHistory
+		json.Unmarshal(w.Body.Bytes(), &history)
+		return w, history
+	}
+
+	// The exact match wins over the files ending with the path
+	w, history := get("main.go")
+	if w.Code != http.StatusOK || len(history.Builds) != 1 || history.Builds[0].CoverageRate != 100 {
+		t.Errorf("Expected the history of main.go alone, got %d %s", w.Code, w.Body.String())
+	}
+
+	// A unique suffix resolves to the file
+	w, history = get("a/main.go")
+	if w.Code != http.StatusOK || len(history.Builds) != 1 || history.Builds[0].CoverageRate != 0 {
+		t.Errorf("Expected the history of cmd/a/main.go, got %d %s", w.Code, w.Body.String())
+	}
+
+	// LIKE wildcards in the path match literally
+	for _, path := range []string{"x_y.go", "x%25.go"} {
+		w, history = get(path)
+		if w.Code != http.StatusOK || history.Builds[0].Present {
+			t.Errorf("Expected %s not to match pkg/xzy.go, got %d %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	// Without an exact match, a suffix matching several files is rejected with the candidates
+	db.Where("name = ?", "main.go").Delete(&models.JobFile{})
+	w, _ = get("main.go")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	var body struct {
+		Candidates []string `json:"candidates"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if !reflect.DeepEqual(body.Candidates, []string{"cmd/a/main.go", "cmd/b/main.go"}) {
+		t.Errorf("Expected both main.go files as candidates, got %v", body.Candidates)
+	}
+}
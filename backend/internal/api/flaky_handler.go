@@ -0,0 +1,293 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Frantche/Librecov/backend/internal/middleware"
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FlakyFile lists the flaky lines of a file
+type FlakyFile struct {
+	Name  string          `json:"name"`
+	Lines []FlakyLineInfo `json:"lines"`
+}
+
+// FlakyLineInfo is a flaky line and the number of times its coverage flipped
+type FlakyLineInfo struct {
+	Line  int `json:"line"`
+	Flips int `json:"flips"`
+}
+
+// FlakyReport lists the flaky lines of a project, grouped by file
+type FlakyReport struct {
+	ProjectID         string      `json:"project_id"`
+	Branch            string      `json:"branch"`
+	ExcludeFlakyLines bool        `json:"exclude_flaky_lines"`
+	Lines             int         `json:"lines"`
+	Files             []FlakyFile `json:"files"`
+}
+
+// GetFlaky returns the flaky lines found by the last analysis of a project
+//
+//	@Summary		List flaky lines
+//	@Description	Get the lines whose coverage toggles between builds while their file is unchanged, as found by the last analysis
+//	@Tags			projects
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Project ID"
+//	@Success		200	{object}	FlakyReport
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/flaky [get]
+func (h *ProjectHandler) GetFlaky(c *gin.Context) {
+	projectID := c.Param("id")
+
+	var project models.Project
+	if err := h.db.Where("id = ?", projectID).First(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
+		}
+		return
+	}
+
+	var lines []models.FlakyLine
+	if err := h.db.Where("project_id = ?", project.ID).Order("file_name, line").Find(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flaky lines"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newFlakyReport(&project, lines))
+}
+
+// AnalyzeFlaky scans the recent builds of a branch for flaky lines and stores them
+//
+//	@Summary		Detect flaky lines
+//	@Description	Scan the recent builds of a branch for lines whose coverage toggles while their file is unchanged, and replace the stored flaky lines of the project
+//	@Tags			projects
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Project ID"
+//	@Param			branch		query		string	false	"Branch (defaults to the project's current branch)"
+//	@Param			builds		query		int		false	"Number of recent builds to scan (default 20, max 200)"
+//	@Param			min_flips	query		int		false	"Minimum number of covered/uncovered transitions (default 2)"
+//	@Success		200			{object}	FlakyReport
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/flaky/analyze [post]
+func (h *ProjectHandler) AnalyzeFlaky(c *gin.Context) {
+	projectID := c.Param("id")
+	user, exists := middleware.GetCurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	// Verify user owns the project
+	var project models.Project
+	query := h.db
+	if !user.Admin {
+		query = query.Where("user_id = ?", user.ID)
+	}
+
+	if err := query.Where("id = ?", projectID).First(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
+		}
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("builds", "20"))
+	if err != nil || limit < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "builds must be at least 2"})
+		return
+	}
+	limit = min(limit, 200)
+
+	minFlips, err := strconv.Atoi(c.DefaultQuery("min_flips", "2"))
+	if err != nil || minFlips < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_flips must be at least 1"})
+		return
+	}
+
+	branch := c.DefaultQuery("branch", project.CurrentBranch)
+
+	lines, err := detectFlakyLines(h.db, project.ID, branch, limit, minFlips)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze builds"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("project_id = ?", project.ID).Delete(&models.FlakyLine{}).Error; err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&lines, 500).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store flaky lines"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newFlakyReport(&project, lines))
+}
+
+// newFlakyReport groups flaky lines by file
+func newFlakyReport(project *models.Project, lines []models.FlakyLine) FlakyReport {
+	report := FlakyReport{
+		ProjectID:         project.ID,
+		ExcludeFlakyLines: project.ExcludeFlakyLines,
+		Lines:             len(lines),
+		Files:             []FlakyFile{},
+	}
+
+	for _, l := range lines {
+		report.Branch = l.Branch
+		n := len(report.Files)
+		if n == 0 || report.Files[n-1].Name != l.FileName {
+			report.Files = append(report.Files, FlakyFile{Name: l.FileName})
+			n++
+		}
+		report.Files[n-1].Lines = append(report.Files[n-1].Lines, FlakyLineInfo{Line: l.Line, Flips: l.Flips})
+	}
+
+	return report
+}
+
+// detectFlakyLines finds the lines whose covered status flips at least
+// minFlips times across the recent builds of a branch while the file is
+// unchanged. A file is unchanged when its source digest is the same; for
+// files uploaded without source, the set of relevant lines must match.
+func detectFlakyLines(db *gorm.DB, projectID, branch string, limit, minFlips int) ([]models.FlakyLine, error) {
+	builds, err := recentBuilds(db, projectID, branch, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(builds) < 2 {
+		return []models.FlakyLine{}, nil
+	}
+
+	snapshots, err := loadFileSnapshots(db, builds)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flaky := []models.FlakyLine{}
+	for _, name := range names {
+		for line, flips := range countCoverageFlips(snapshots[name]) {
+			if flips >= minFlips {
+				flaky = append(flaky, models.FlakyLine{
+					ProjectID: projectID,
+					Branch:    branch,
+					FileName:  name,
+					Line:      line,
+					Flips:     flips,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(flaky, func(i, j int) bool {
+		if flaky[i].FileName != flaky[j].FileName {
+			return flaky[i].FileName < flaky[j].FileName
+		}
+		return flaky[i].Line < flaky[j].Line
+	})
+
+	return flaky, nil
+}
+
+// countCoverageFlips returns, per 1-based line, the highest number of
+// covered/uncovered transitions within a run of builds where the file did
+// not change. Builds that did not report the file are skipped.
+func countCoverageFlips(snapshots []*fileSnapshot) map[int]int {
+	best := make(map[int]int)
+	flips := make(map[int]int)
+	var previous *fileSnapshot
+
+	for _, snap := range snapshots {
+		if snap == nil {
+			continue
+		}
+		if previous != nil && !sameFileVersion(previous, snap) {
+			flips = make(map[int]int)
+		} else if previous != nil {
+			for i, hits := range snap.Lines {
+				if hits == lineNotRelevant || i >= len(previous.Lines) || previous.Lines[i] == lineNotRelevant {
+					continue
+				}
+				if (hits > 0) != (previous.Lines[i] > 0) {
+					flips[i+1]++
+					best[i+1] = max(best[i+1], flips[i+1])
+				}
+			}
+		}
+		previous = snap
+	}
+
+	return best
+}
+
+// loadFlakyLines returns the stored flaky lines of a project as file name -> line set
+func loadFlakyLines(db *gorm.DB, projectID string) (map[string]map[int]bool, error) {
+	var lines []models.FlakyLine
+	if err := db.Select("file_name, line").Where("project_id = ?", projectID).Find(&lines).Error; err != nil {
+		return nil, err
+	}
+
+	flaky := make(map[string]map[int]bool)
+	for _, l := range lines {
+		if flaky[l.FileName] == nil {
+			flaky[l.FileName] = make(map[int]bool)
+		}
+		flaky[l.FileName][l.Line] = true
+	}
+	return flaky, nil
+}
+
+// excludeFlakyLines marks the flaky lines of the given files as not relevant
+func excludeFlakyLines(files map[string]*fileCoverage, flaky map[string]map[int]bool) {
+	for name, lines := range flaky {
+		file, ok := files[name]
+		if !ok {
+			continue
+		}
+		for line := range lines {
+			if line >= 1 && line <= len(file.Lines) {
+				file.Lines[line-1] = lineNotRelevant
+			}
+		}
+	}
+}
+
+// totalCoverageRate returns the line coverage rate of a set of files
+func totalCoverageRate(files map[string]*fileCoverage) float64 {
+	total, covered := 0, 0
+	for _, file := range files {
+		relevant, hit := file.counts()
+		total += relevant
+		covered += hit
+	}
+	return coverageRate(covered, total)
+}
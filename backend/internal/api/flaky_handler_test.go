@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCountCoverageFlips(t *testing.T) {
+	snapshots := []*fileSnapshot{
+		{Digest: "v1", Lines: []int{1, 1, 0}},
+		{Digest: "v1", Lines: []int{1, 0, 0}},
+		nil, // build without the file
+		{Digest: "v1", Lines: []int{1, 2, 0}},
+		{Digest: "v2", Lines: []int{1, 0, 1}}, // source changed, not a flip
+	}
+
+	flips := countCoverageFlips(snapshots)
+	if flips[2] != 2 {
+		t.Errorf("Expected line 2 to flip twice, got %d", flips[2])
+	}
+	if flips[3] != 0 {
+		t.Errorf("Expected line 3 not to flip across a source change, got %d", flips[3])
+	}
+}
+
+func TestAnalyzeFlakyExcludesLinesFromGate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	user := models.User{Email: "owner@example.com"}
+	db.Create(&user)
+	project := models.Project{Name: "Flaky", Token: "flaky-token", CurrentBranch: "main", UserID: user.ID}
+	db.Create(&project)
+
+	createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{"a.go": `[1, 1]`})
+	createTestBuild(t, db, project.ID, 2, "main", "c2", map[string]string{"a.go": `[1, 0]`})
+	last := createTestBuild(t, db, project.ID, 3, "main", "c3", map[string]string{"a.go": `[1, 1]`})
+	flaking := createTestBuild(t, db, project.ID, 4, "feature", "f1", map[string]string{"a.go": `[1, 0]`})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/projects/:id/flaky/analyze", func(c *gin.Context) {
+		c.Set("user", &user)
+		NewProjectHandler(db).AnalyzeFlaky(c)
+	})
+
+	req := httptest.NewRequest("POST", "/projects/"+project.ID+"/flaky/analyze", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var report FlakyReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if report.Lines != 1 || len(report.Files) != 1 || report.Files[0].Lines[0].Line != 2 {
+		t.Fatalf("Expected line 2 of a.go to be flaky, got %+v", report)
+	}
+
+	maxDrop := 0.0
+	db.Create(&models.QualityGate{ProjectID: project.ID, MaxDrop: &maxDrop})
+
+	if err := evaluateQualityGate(db, &flaking); err != nil {
+		t.Fatalf("Failed to evaluate quality gate: %v", err)
+	}
+	if flaking.GateStatus != GateStatusFailed {
+		t.Errorf("Expected the drop to fail the gate while flaky lines count, got %q", flaking.GateStatus)
+	}
+
+	db.Model(&project).Update("exclude_flaky_lines", true)
+	if err := evaluateQualityGate(db, &flaking); err != nil {
+		t.Fatalf("Failed to evaluate quality gate: %v", err)
+	}
+	if flaking.GateStatus != GateStatusPassed {
+		t.Errorf("Expected the gate to pass without flaky lines, got %q (base build %d)", flaking.GateStatus, last.BuildNum)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FunctionCoverage is a function of a build and the number of times it was called
+type FunctionCoverage struct {
+	File      string `json:"file"`
+	Name      string `json:"name"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Hits      int    `json:"hits"`
+	Exported  bool   `json:"exported"`
+}
+
+// BuildFunctions lists the functions of a build
+type BuildFunctions struct {
+	BuildID          uint               `json:"build_id"`
+	TotalFunctions   int                `json:"total_functions"`
+	CoveredFunctions int                `json:"covered_functions"`
+	CoverageRate     float64            `json:"coverage_rate"`
+	Functions        []FunctionCoverage `json:"functions"`
+}
+
+// Functions lists the functions of a build, optionally only the uncovered ones
+//
+//	@Summary		List build functions
+//	@Description	Get the function coverage of a build. Totals cover every function of the build; the list honours the filters.
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Build ID"
+//	@Param			uncovered	query		bool	false	"Only list functions that were never called"
+//	@Param			exported	query		bool	false	"Only list exported functions (name starting with an upper-case letter)"
+//	@Param			path		query		string	false	"Only list functions of files under this directory"
+//	@Success		200			{object}	BuildFunctions
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/functions [get]
+func (h *BuildHandler) Functions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid build ID"})
+		return
+	}
+
+	if err := h.db.Select("id").First(&models.Build{}, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	functions, err := loadBuildFunctions(h.db, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch functions"})
+		return
+	}
+
+	result := BuildFunctions{BuildID: uint(id), Functions: []FunctionCoverage{}}
+	uncovered := c.Query("uncovered") == "true"
+	exported := c.Query("exported") == "true"
+	prefix := normalizeTreePath(c.Query("path"))
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	for _, fn := range functions {
+		result.TotalFunctions++
+		if fn.Hits > 0 {
+			result.CoveredFunctions++
+		}
+
+		if (uncovered && fn.Hits > 0) || (exported && !fn.Exported) ||
+			!strings.HasPrefix(normalizeTreePath(fn.File), prefix) {
+			continue
+		}
+		result.Functions = append(result.Functions, fn)
+	}
+	result.CoverageRate = coverageRate(result.CoveredFunctions, result.TotalFunctions)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// loadBuildFunctions loads the functions of every job of a build, ordered by
+// file and position. Functions reported by several jobs are merged and their
+// hits add up.
+func loadBuildFunctions(db *gorm.DB, buildID uint) ([]FunctionCoverage, error) {
+	return queryFunctions(db.Where("jobs.build_id = ?", buildID))
+}
+
+// loadJobFunctions loads the functions of a single job, like loadBuildFunctions
+func loadJobFunctions(db *gorm.DB, jobID uint) ([]FunctionCoverage, error) {
+	return queryFunctions(db.Where("jobs.id = ?", jobID))
+}
+
+// queryFunctions loads the functions of the jobs selected by a query
+func queryFunctions(query *gorm.DB) ([]FunctionCoverage, error) {
+	var rows []struct {
+		File      string
+		Name      string
+		StartLine int
+		EndLine   int
+		Hits      int
+	}
+	if err := query.Table("file_functions").
+		Select("job_files.name AS file, file_functions.name, file_functions.start_line, file_functions.end_line, file_functions.hits").
+		Joins("JOIN job_files ON job_files.id = file_functions.job_file_id AND job_files.deleted_at IS NULL").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("file_functions.deleted_at IS NULL").
+		Order("file_functions.id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	type functionKey struct {
+		File  string
+		Name  string
+		Start int
+	}
+	merged := make(map[functionKey]int)
+	functions := []FunctionCoverage{}
+	for _, r := range rows {
+		key := functionKey{r.File, r.Name, r.StartLine}
+		if i, ok := merged[key]; ok {
+			functions[i].Hits += r.Hits
+			continue
+		}
+		merged[key] = len(functions)
+		functions = append(functions, FunctionCoverage{
+			File:      r.File,
+			Name:      r.Name,
+			StartLine: r.StartLine,
+			EndLine:   r.EndLine,
+			Hits:      r.Hits,
+			Exported:  isExportedFunction(r.Name),
+		})
+	}
+
+	sort.SliceStable(functions, func(i, j int) bool {
+		if functions[i].File != functions[j].File {
+			return functions[i].File < functions[j].File
+		}
+		return functions[i].StartLine < functions[j].StartLine
+	})
+
+	return functions, nil
+}
+
+// isExportedFunction tells whether a function name is exported, Go style:
+// the part after the last dot, which skips receivers such as "(*T).", must
+// start with an upper-case letter.
+func isExportedFunction(name string) bool {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
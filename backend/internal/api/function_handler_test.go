@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUploadFunctionsAndListUncovered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Functions", Token: "functions-token"}
+	db.Create(&project)
+
+	coverageData := map[string]interface{}{
+		"repo_token": "functions-token",
+		"source_files": []map[string]interface{}{
+			{
+				"name":     "pkg/api.go",
+				"coverage": []interface{}{1, 1, 0, 0},
+				"functions": []map[string]interface{}{
+					{"name": "Serve", "start_line": 1, "end_line": 2, "hits": 3},
+					{"name": "(*Server).Close", "start_line": 3, "end_line": 3, "hits": 0},
+					{"name": "helper", "start_line": 4, "end_line": 4, "hits": 0},
+				},
+			},
+			{"name": "main.go", "coverage": []interface{}{1}},
+		},
+	}
+	jsonData, _ := json.Marshal(coverageData)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/v2", NewJobHandler(db).Upload)
+	router.GET("/builds/:id/functions", NewBuildHandler(db).Functions)
+
+	req := httptest.NewRequest("POST", "/upload/v2", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var build models.Build
+	db.First(&build)
+	if build.FunctionCoverageRate == nil || *build.FunctionCoverageRate < 33.3 || *build.FunctionCoverageRate > 33.4 {
+		t.Errorf("Expected build function coverage rate of 1/3, got %v", build.FunctionCoverageRate)
+	}
+	var file models.JobFile
+	db.Where("name = ?", "main.go").First(&file)
+	if file.FunctionCoverageRate != nil {
+		t.Errorf("Expected no function coverage rate for a file without functions, got %v", *file.FunctionCoverageRate)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/functions?uncovered=true&exported=true", build.ID), nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result BuildFunctions
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.TotalFunctions != 3 || result.CoveredFunctions != 1 {
+		t.Errorf("Expected 1 of 3 functions covered, got %d of %d", result.CoveredFunctions, result.TotalFunctions)
+	}
+	if len(result.Functions) != 1 || result.Functions[0].Name != "(*Server).Close" {
+		t.Errorf("Expected only (*Server).Close to be listed, got %+v", result.Functions)
+	}
+}
@@ -0,0 +1,630 @@
+package api
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Magic strings of the files written by Go binaries built with -cover
+var (
+	covMetaMagic    = [4]byte{0x00, 'c', 'v', 'm'}
+	covCounterMagic = [4]byte{0x00, 'c', 'w', 'm'}
+)
+
+// Sizes of the fixed headers of the coverage file formats
+const (
+	covMetaFileHeaderSize    = 56 // magic, version, total length, entries, hash, string table offset and length, mode, granularity, padding
+	covMetaPackageHeaderSize = 44 // length, package name, path and module, hash, padding, files, functions
+	covCounterHeaderSize     = 32 // magic, version, meta hash, flavor, big endian, padding
+	covCounterSegmentSize    = 16 // function entries, string table length, args length
+	covCounterFooterSize     = 16 // magic, padding, segments, padding
+)
+
+// Limits on uploaded GOCOVERDIR archives, checked while they are read
+const (
+	maxGoCoverDirUploadSize    = 64 << 20  // Request body, compressed
+	maxGoCoverDirExtractedSize = 256 << 20 // Archive once decompressed
+	maxGoCoverDirFileSize      = 64 << 20  // Each covmeta or covcounters file
+	maxGoCoverLine             = 1 << 20   // Line numbers of coverable blocks
+	maxGoCoverLines            = 1 << 24   // Lines spanned by all blocks and files
+)
+
+// errGoCoverDirTooLarge is returned when an archive exceeds one of the size limits
+var errGoCoverDirTooLarge = errors.New("archive too large")
+
+// Counter encodings of covcounters files
+const (
+	covCounterRaw     = 1
+	covCounterULeb128 = 2
+)
+
+// covUnit is a coverable block of a function
+type covUnit struct {
+	StartLine, EndLine int
+	Statements         int
+}
+
+// covFunc is a function described by a covmeta file
+type covFunc struct {
+	Name  string
+	File  string
+	Units []covUnit
+	Lit   bool // Function literal
+}
+
+// covPackage is a package described by a covmeta file
+type covPackage struct {
+	Path       string
+	ModulePath string
+	Funcs      []covFunc
+}
+
+// covMeta is a decoded covmeta file
+type covMeta struct {
+	Hash     [16]byte
+	Packages []covPackage
+}
+
+// covFuncKey identifies a function in the packages of a covmeta file
+type covFuncKey struct {
+	Pkg, Func uint32
+}
+
+// UploadGoCoverDir stores the coverage of a GOCOVERDIR directory as a new build and job
+//
+//	@Summary		Upload Go binary coverage data
+//	@Description	Upload a tarball (optionally gzipped) of a GOCOVERDIR directory written by a Go 1.20+ binary built with -cover. Counters of every process are merged and stored like a Coveralls upload. Paths are made relative to the module root.
+//	@Tags			coverage
+//	@Accept			application/x-tar
+//	@Produce		json
+//	@Param			repo_token		query		string	true	"Project repo token"
+//	@Param			branch			query		string	false	"Git branch"
+//	@Param			commit_sha		query		string	false	"Git commit SHA"
+//	@Param			commit_message	query		string	false	"Git commit message"
+//	@Param			service_name	query		string	false	"CI provider"
+//	@Param			service_number	query		string	false	"CI run number"
+//	@Param			service_job_id	query		string	false	"CI job ID"
+//	@Param			flag_name		query		string	false	"Flag of the job, e.g. e2e"
+//	@Success		200				{object}	map[string]interface{}
+//	@Failure		400				{object}	map[string]string
+//	@Failure		401				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Router			/upload/gocoverdir [post]
+func (h *JobHandler) UploadGoCoverDir(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGoCoverDirUploadSize)
+	if c.ContentType() == "multipart/form-data" {
+		if _, err := c.MultipartForm(); err != nil {
+			respondGoCoverDirError(c, err)
+			return
+		}
+	}
+
+	// The archive is only decompressed for uploads with a valid repo token
+	repoToken := reportParam(c, "repo_token")
+	if repoToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid repo token"})
+		return
+	}
+	if err := h.db.Select("id").Where("token = ?", repoToken).First(&models.Project{}).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid repo token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	body := io.Reader(c.Request.Body)
+	if c.ContentType() == "multipart/form-data" {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read archive"})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	metas, counters, err := readGoCoverDir(body)
+	if err != nil {
+		respondGoCoverDirError(c, err)
+		return
+	}
+
+	sourceFiles, err := goCoverageToSourceFiles(metas, counters)
+	if err != nil {
+		respondGoCoverDirError(c, err)
+		return
+	}
+
+	upload := CoverallsUpload{
+		RepoToken:     reportParam(c, "repo_token"),
+		ServiceName:   reportParam(c, "service_name"),
+		ServiceNumber: reportParam(c, "service_number"),
+		ServiceJobID:  reportParam(c, "service_job_id"),
+		FlagName:      reportParam(c, "flag_name"),
+		Git:           &CoverallsGit{Branch: reportParam(c, "branch")},
+		SourceFiles:   sourceFiles,
+	}
+	upload.Git.Head.ID = reportParam(c, "commit_sha")
+	upload.Git.Head.Message = reportParam(c, "commit_message")
+
+	h.storeUpload(c, &upload)
+}
+
+// respondGoCoverDirError writes the error response for an archive that cannot be read
+func respondGoCoverDirError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errGoCoverDirTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "GOCOVERDIR archive too large"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GOCOVERDIR archive", "details": err.Error()})
+}
+
+// readGoCoverDir reads the covmeta and covcounters files of a tar archive,
+// gzipped or not. Counters are merged across files and keyed by the hash of
+// the covmeta file they belong to. Archives and files past the size limits
+// fail with errGoCoverDirTooLarge.
+func readGoCoverDir(r io.Reader) (map[[16]byte]*covMeta, map[[16]byte]map[covFuncKey][]uint32, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	r = &cappedReader{r: r, left: maxGoCoverDirExtractedSize}
+
+	metas := make(map[[16]byte]*covMeta)
+	counters := make(map[[16]byte]map[covFuncKey][]uint32)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Base(hdr.Name)
+		switch {
+		case strings.HasPrefix(name, "covmeta."):
+			data, err := readGoCoverFile(tr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			meta, err := decodeCovMeta(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			metas[meta.Hash] = meta
+		case strings.HasPrefix(name, "covcounters."):
+			data, err := readGoCoverFile(tr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if err := decodeCovCounters(data, counters); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	if len(metas) == 0 {
+		return nil, nil, fmt.Errorf("no covmeta file found")
+	}
+	return metas, counters, nil
+}
+
+// readGoCoverFile reads a file of the archive, failing past maxGoCoverDirFileSize
+func readGoCoverFile(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxGoCoverDirFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGoCoverDirFileSize {
+		return nil, errGoCoverDirTooLarge
+	}
+	return data, nil
+}
+
+// cappedReader fails with errGoCoverDirTooLarge once more than a number of
+// bytes were read, where io.LimitReader would silently end the stream
+type cappedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (r *cappedReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, errGoCoverDirTooLarge
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= int64(n)
+	return n, err
+}
+
+// goCoverageToSourceFiles converts decoded coverage into Coveralls source
+// files. A line gets the highest count of the blocks spanning it, and
+// function literals are left out of the function list.
+func goCoverageToSourceFiles(metas map[[16]byte]*covMeta, counters map[[16]byte]map[covFuncKey][]uint32) ([]CoverallsSourceFile, error) {
+	type fileHits struct {
+		lines     map[int]int
+		maxLine   int
+		functions []UploadFunction
+	}
+	files := make(map[string]*fileHits)
+	budget := maxGoCoverLines
+
+	for hash, meta := range metas {
+		for p, pkg := range meta.Packages {
+			for f, fn := range pkg.Funcs {
+				name := fn.File
+				if pkg.ModulePath != "" {
+					name = strings.TrimPrefix(name, pkg.ModulePath+"/")
+				}
+				file, ok := files[name]
+				if !ok {
+					file = &fileHits{lines: make(map[int]int)}
+					files[name] = file
+				}
+
+				values := counters[hash][covFuncKey{uint32(p), uint32(f)}]
+				start, end, entryHits := 0, 0, 0
+				for u, unit := range fn.Units {
+					hits := 0
+					if len(values) == 1 {
+						// Per-function granularity
+						hits = int(values[0])
+					} else if u < len(values) {
+						hits = int(values[u])
+					}
+					if u == 0 {
+						start, entryHits = unit.StartLine, hits
+					}
+					end = max(end, unit.EndLine)
+					if unit.Statements == 0 {
+						continue
+					}
+					if budget -= unit.EndLine - unit.StartLine + 1; budget < 0 {
+						return nil, fmt.Errorf("coverable blocks span more than %d lines", maxGoCoverLines)
+					}
+					for line := unit.StartLine; line <= unit.EndLine; line++ {
+						if current, ok := file.lines[line]; !ok || hits > current {
+							file.lines[line] = hits
+						}
+					}
+					file.maxLine = max(file.maxLine, unit.EndLine)
+				}
+
+				if !fn.Lit && len(fn.Units) > 0 {
+					file.functions = append(file.functions, UploadFunction{
+						Name:      fn.Name,
+						StartLine: start,
+						EndLine:   end,
+						Hits:      entryHits,
+					})
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Blocks far down in many files would need large coverage arrays
+	budget = maxGoCoverLines
+	for _, name := range names {
+		if budget -= files[name].maxLine; budget < 0 {
+			return nil, fmt.Errorf("files span more than %d lines", maxGoCoverLines)
+		}
+	}
+
+	sourceFiles := make([]CoverallsSourceFile, 0, len(names))
+	for _, name := range names {
+		file := files[name]
+		coverage := make([]interface{}, file.maxLine)
+		for line, hits := range file.lines {
+			coverage[line-1] = float64(hits)
+		}
+		sort.Slice(file.functions, func(i, j int) bool { return file.functions[i].StartLine < file.functions[j].StartLine })
+		sourceFiles = append(sourceFiles, CoverallsSourceFile{
+			Name:      name,
+			Coverage:  coverage,
+			Functions: file.functions,
+		})
+	}
+	return sourceFiles, nil
+}
+
+// covReader reads little-endian values from a byte slice, remembering the first out of bounds read
+type covReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *covReader) seek(off int) {
+	if off < 0 || off > len(r.b) {
+		r.fail()
+		return
+	}
+	r.off = off
+}
+
+func (r *covReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("truncated coverage data")
+	}
+	r.off = len(r.b)
+}
+
+func (r *covReader) bytes(n int) []byte {
+	if n < 0 || r.off+n > len(r.b) {
+		r.fail()
+		return make([]byte, max(n, 0))
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *covReader) uint8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *covReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *covReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.bytes(8))
+}
+
+func (r *covReader) uleb128() uint64 {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b := r.uint8()
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 || r.err != nil {
+			return value
+		}
+	}
+	r.fail()
+	return 0
+}
+
+// stringTable reads a string table: a count followed by length-prefixed strings
+func (r *covReader) stringTable() []string {
+	n := r.uleb128()
+	if n > uint64(len(r.b)) {
+		r.fail()
+		return nil
+	}
+	strs := make([]string, 0, n)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		strs = append(strs, string(r.bytes(int(r.uleb128()))))
+	}
+	return strs
+}
+
+// lookupString returns an entry of a string table, recording an error for bad indexes
+func (r *covReader) lookupString(strs []string, idx uint64) string {
+	if idx >= uint64(len(strs)) {
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid string table index %d", idx)
+		}
+		return ""
+	}
+	return strs[idx]
+}
+
+// decodeCovMeta decodes a covmeta file
+func decodeCovMeta(data []byte) (*covMeta, error) {
+	r := &covReader{b: data}
+	if !bytes.Equal(r.bytes(4), covMetaMagic[:]) {
+		return nil, fmt.Errorf("not a covmeta file")
+	}
+	if version := r.uint32(); version != 1 {
+		return nil, fmt.Errorf("unsupported covmeta version %d", version)
+	}
+	r.uint64() // total length
+	entries := r.uint64()
+	meta := &covMeta{}
+	copy(meta.Hash[:], r.bytes(16))
+	r.seek(covMetaFileHeaderSize)
+	if entries > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid package count %d", entries)
+	}
+
+	offsets := make([]uint64, entries)
+	for i := range offsets {
+		offsets[i] = r.uint64()
+	}
+	lengths := make([]uint64, entries)
+	for i := range lengths {
+		lengths[i] = r.uint64()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for i := range offsets {
+		if offsets[i]+lengths[i] > uint64(len(data)) {
+			return nil, fmt.Errorf("package %d out of bounds", i)
+		}
+		pkg, err := decodeCovMetaPackage(data[offsets[i] : offsets[i]+lengths[i]])
+		if err != nil {
+			return nil, fmt.Errorf("package %d: %w", i, err)
+		}
+		meta.Packages = append(meta.Packages, *pkg)
+	}
+	return meta, nil
+}
+
+// decodeCovMetaPackage decodes the meta-data of a package: a header, the
+// offsets of its functions, a string table and the function descriptions
+func decodeCovMetaPackage(data []byte) (*covPackage, error) {
+	r := &covReader{b: data}
+	r.uint32() // length
+	r.uint32() // package name
+	pkgPath := r.uint32()
+	modulePath := r.uint32()
+	r.bytes(16 + 4) // hash and padding
+	r.uint32()      // files
+	numFuncs := r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if uint64(numFuncs)*4 > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid function count %d", numFuncs)
+	}
+
+	r.seek(covMetaPackageHeaderSize + 4*int(numFuncs))
+	strs := r.stringTable()
+
+	pkg := &covPackage{
+		Path:       r.lookupString(strs, uint64(pkgPath)),
+		ModulePath: r.lookupString(strs, uint64(modulePath)),
+	}
+	for f := 0; f < int(numFuncs) && r.err == nil; f++ {
+		r.seek(covMetaPackageHeaderSize + 4*f)
+		r.seek(int(r.uint32()))
+
+		numUnits := r.uleb128()
+		fn := covFunc{
+			Name: r.lookupString(strs, r.uleb128()),
+			File: r.lookupString(strs, r.uleb128()),
+		}
+		for u := uint64(0); u < numUnits && r.err == nil; u++ {
+			startLine := r.uleb128()
+			r.uleb128() // start column
+			endLine := r.uleb128()
+			r.uleb128() // end column
+			statements := r.uleb128()
+			if r.err != nil {
+				break
+			}
+			if startLine < 1 || startLine > endLine || endLine > maxGoCoverLine {
+				return nil, fmt.Errorf("%s: invalid block of lines %d-%d", fn.Name, startLine, endLine)
+			}
+			fn.Units = append(fn.Units, covUnit{StartLine: int(startLine), EndLine: int(endLine), Statements: int(statements)})
+		}
+		fn.Lit = r.uleb128() != 0
+		pkg.Funcs = append(pkg.Funcs, fn)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return pkg, nil
+}
+
+// decodeCovCounters decodes a covcounters file and adds its counters to the
+// ones already read for the same covmeta file
+func decodeCovCounters(data []byte, counters map[[16]byte]map[covFuncKey][]uint32) error {
+	r := &covReader{b: data}
+	if !bytes.Equal(r.bytes(4), covCounterMagic[:]) {
+		return fmt.Errorf("not a covcounters file")
+	}
+	if version := r.uint32(); version != 1 {
+		return fmt.Errorf("unsupported covcounters version %d", version)
+	}
+	var hash [16]byte
+	copy(hash[:], r.bytes(16))
+	flavor := r.uint8()
+	bigEndian := r.uint8() != 0
+	if r.err != nil {
+		return r.err
+	}
+
+	readValue := func() uint32 {
+		switch {
+		case flavor == covCounterULeb128:
+			return uint32(r.uleb128())
+		case bigEndian:
+			return binary.BigEndian.Uint32(r.bytes(4))
+		default:
+			return r.uint32()
+		}
+	}
+	if flavor != covCounterRaw && flavor != covCounterULeb128 {
+		return fmt.Errorf("unknown counter flavor %d", flavor)
+	}
+
+	r.seek(len(data) - covCounterFooterSize)
+	if !bytes.Equal(r.bytes(4), covCounterMagic[:]) {
+		return fmt.Errorf("invalid covcounters footer")
+	}
+	r.uint32() // padding
+	segments := r.uint32()
+
+	merged := counters[hash]
+	if merged == nil {
+		merged = make(map[covFuncKey][]uint32)
+		counters[hash] = merged
+	}
+
+	r.seek(covCounterHeaderSize)
+	for s := uint32(0); s < segments && r.err == nil; s++ {
+		if s > 0 {
+			r.bytes(covCounterFooterSize) // footer of the previous segment
+		}
+		entries := r.uint64()
+		strTabLen := r.uint32()
+		argsLen := r.uint32()
+		r.bytes(int(strTabLen) + int(argsLen))
+		if rem := r.off % 4; rem != 0 {
+			r.bytes(4 - rem)
+		}
+
+		for e := uint64(0); e < entries && r.err == nil; e++ {
+			n := readValue()
+			key := covFuncKey{Pkg: readValue(), Func: readValue()}
+			if uint64(n) > uint64(len(data)) {
+				return fmt.Errorf("invalid counter count %d", n)
+			}
+			values := merged[key]
+			for len(values) < int(n) {
+				values = append(values, 0)
+			}
+			for i := 0; i < int(n); i++ {
+				values[i] += readValue()
+			}
+			merged[key] = values
+		}
+	}
+	return r.err
+}
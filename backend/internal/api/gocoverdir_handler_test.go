@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// testdata/gocoverdir.tar.gz holds the GOCOVERDIR of three runs of a small
+// program built with -cover, module example.com/svc:
+//
+//	calc/calc.go: Add (lines 5-6) called 3 times, Div (lines 10-13) called
+//	once with b != 0, unused (lines 17-18) never called
+func TestUploadGoCoverDir(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "GoCoverDir", Token: "gocoverdir-token"}
+	db.Create(&project)
+
+	archive, err := os.ReadFile("testdata/gocoverdir.tar.gz")
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/gocoverdir", NewJobHandler(db).UploadGoCoverDir)
+
+	req := httptest.NewRequest("POST", "/upload/gocoverdir?repo_token=gocoverdir-token&branch=main&commit_sha=abc123", bytes.NewReader(archive))
+	req.Header.Set("Content-Type", "application/gzip")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result struct {
+		BuildID uint `json:"build_id"`
+		JobID   uint `json:"job_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+
+	var build models.Build
+	db.First(&build, result.BuildID)
+	if build.Branch != "main" || build.CommitSHA != "abc123" {
+		t.Errorf("Expected the build of main at abc123, got %q at %q", build.Branch, build.CommitSHA)
+	}
+
+	var file models.JobFile
+	if err := db.Preload("Functions").Where("job_id = ? AND name = ?", result.JobID, "calc/calc.go").First(&file).Error; err != nil {
+		t.Fatalf("Expected calc/calc.go relative to the module root: %v", err)
+	}
+
+	lines := parseLineHits(file.Coverage)
+	expected := map[int]int{5: 3, 6: 3, 10: 1, 11: 0, 12: 0, 13: 1, 17: 0, 18: 0}
+	for line, hits := range expected {
+		if got := lines[line-1]; got != hits {
+			t.Errorf("Expected %d hits on line %d, got %d", hits, line, got)
+		}
+	}
+	if lines[0] != lineNotRelevant || lines[8] != lineNotRelevant {
+		t.Errorf("Expected lines outside blocks to be irrelevant, got %v", lines)
+	}
+
+	hits := make(map[string]int)
+	for _, fn := range file.Functions {
+		hits[fn.Name] = fn.Hits
+	}
+	if len(hits) != 3 || hits["Add"] != 3 || hits["Div"] != 1 || hits["unused"] != 0 {
+		t.Errorf("Expected Add, Div and unused called 3, 1 and 0 times, got %v", hits)
+	}
+
+	// Anything else than a tarball of coverage files is rejected
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/upload/gocoverdir?repo_token=gocoverdir-token", bytes.NewReader([]byte("not a tarball")))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid archive, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestUploadGoCoverDirRequiresToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/upload/gocoverdir", NewJobHandler(db).UploadGoCoverDir)
+
+	// The token is checked before the archive is read
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/upload/gocoverdir?repo_token=unknown", bytes.NewReader([]byte("not a tarball")))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+// testCovMetaPackage encodes the meta-data of a package with a single function made of the given blocks
+func testCovMetaPackage(blocks [][2]uint64) []byte {
+	uleb := func(b []byte, v uint64) []byte { return binary.AppendUvarint(b, v) }
+
+	data := make([]byte, covMetaPackageHeaderSize)
+	binary.LittleEndian.PutUint32(data[8:], 0)  // package path: strings[0]
+	binary.LittleEndian.PutUint32(data[12:], 0) // module path: strings[0]
+	binary.LittleEndian.PutUint32(data[40:], 1) // functions
+	data = binary.LittleEndian.AppendUint32(data, 0)
+
+	data = uleb(data, 2)
+	for _, s := range []string{"example.com/svc", "f"} {
+		data = uleb(data, uint64(len(s)))
+		data = append(data, s...)
+	}
+
+	binary.LittleEndian.PutUint32(data[covMetaPackageHeaderSize:], uint32(len(data)))
+	data = uleb(data, uint64(len(blocks)))
+	data = uleb(data, 1) // name
+	data = uleb(data, 0) // file
+	for _, b := range blocks {
+		data = uleb(uleb(uleb(uleb(uleb(data, b[0]), 1), b[1]), 2), 1)
+	}
+	return uleb(data, 0)
+}
+
+func TestDecodeCovMetaPackageBlocks(t *testing.T) {
+	pkg, err := decodeCovMetaPackage(testCovMetaPackage([][2]uint64{{3, 5}}))
+	if err != nil {
+		t.Fatalf("Failed to decode package: %v", err)
+	}
+	if len(pkg.Funcs) != 1 || len(pkg.Funcs[0].Units) != 1 || pkg.Funcs[0].Units[0] != (covUnit{StartLine: 3, EndLine: 5, Statements: 1}) {
+		t.Errorf("Unexpected package %+v", pkg)
+	}
+
+	for _, block := range [][2]uint64{{0, 5}, {6, 5}, {1, maxGoCoverLine + 1}, {1, 1 << 63}} {
+		if _, err := decodeCovMetaPackage(testCovMetaPackage([][2]uint64{block})); err == nil {
+			t.Errorf("Expected an error for the block of lines %d-%d", block[0], block[1])
+		}
+	}
+}
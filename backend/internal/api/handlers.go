@@ -1,8 +1,10 @@
 package api
 
 import (
+	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/Frantche/Librecov/backend/internal/models"
@@ -171,151 +173,50 @@ func (h *JobHandler) CreateJob(c *gin.Context) {
 		}
 	}
 
-	// Find project by token
-	var project models.Project
-	if err := h.db.Where("token = ?", upload.RepoToken).First(&project).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid repo token"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
-		return
-	}
-
-	// Get or create build
-	var build models.Build
-	commitSHA := ""
-	commitMsg := ""
-	branch := ""
-
-	if upload.Git != nil {
-		commitSHA = upload.Git.Head.ID
-		commitMsg = upload.Git.Head.Message
-		branch = upload.Git.Branch
-	}
-
-	// Get the latest build number for this project
-	var maxBuildNum int
-	h.db.Model(&models.Build{}).Where("project_id = ?", project.ID).Select("COALESCE(MAX(build_num), 0)").Scan(&maxBuildNum)
-
-	build = models.Build{
-		ProjectID: project.ID,
-		BuildNum:  maxBuildNum + 1,
-		Branch:    branch,
-		CommitSHA: commitSHA,
-		CommitMsg: commitMsg,
-	}
-
-	if err := h.db.Create(&build).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create build"})
-		return
-	}
-
-	// Create job
-	jobNumber := upload.ServiceJobID
-	if jobNumber == "" {
-		jobNumber = fmt.Sprintf("%d.1", build.BuildNum)
-	}
-
-	job := models.Job{
-		BuildID:   build.ID,
-		JobNumber: jobNumber,
-	}
-
-	if err := h.db.Create(&job).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job"})
-		return
-	}
-
-	// Process source files and calculate coverage
-	totalLines := 0
-	coveredLines := 0
-
-	for _, sourceFile := range upload.SourceFiles {
-		fileLines := 0
-		fileCovered := 0
-
-		// Calculate coverage for this file
-		for _, cov := range sourceFile.Coverage {
-			if cov != nil {
-				fileLines++
-				if val, ok := cov.(float64); ok && val > 0 {
-					fileCovered++
-				}
-			}
-		}
-
-		totalLines += fileLines
-		coveredLines += fileCovered
-
-		// Calculate file coverage rate
-		var fileCoverageRate float64
-		if fileLines > 0 {
-			fileCoverageRate = (float64(fileCovered) / float64(fileLines)) * 100
-		}
-
-		// Store coverage as JSON string
-		coverageJSON, _ := json.Marshal(sourceFile.Coverage)
-
-		jobFile := models.JobFile{
-			JobID:        job.ID,
-			Name:         sourceFile.Name,
-			Source:       sourceFile.Source,
-			Coverage:     string(coverageJSON),
-			CoverageRate: fileCoverageRate,
-		}
-
-		if err := h.db.Create(&jobFile).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job file"})
-			return
-		}
-	}
-
-	// Calculate overall coverage rate
-	var coverageRate float64
-	if totalLines > 0 {
-		coverageRate = (float64(coveredLines) / float64(totalLines)) * 100
-	}
-
-	// Update job with coverage rate
-	job.CoverageRate = coverageRate
-	h.db.Save(&job)
+	h.storeUpload(c, &upload)
+}
 
-	// Update build with coverage rate
-	build.CoverageRate = coverageRate
-	h.db.Save(&build)
+// CoverallsUpload represents the Coveralls JSON format
+type CoverallsUpload struct {
+	RepoToken       string                `json:"repo_token" binding:"required"`
+	ServiceName     string                `json:"service_name"`
+	ServiceNumber   string                `json:"service_number"`
+	ServiceJobID    string                `json:"service_job_id"`
+	ServiceBuildURL string                `json:"service_build_url"`
+	ServiceJobURL   string                `json:"service_job_url"`
+	FlagName        string                `json:"flag_name"`
+	Git             *CoverallsGit         `json:"git"`
+	SourceFiles     []CoverallsSourceFile `json:"source_files"`
+}
 
-	// Update project with latest coverage rate
-	project.CoverageRate = coverageRate
-	h.db.Save(&project)
+// CoverallsGit is the commit of a Coveralls upload
+type CoverallsGit struct {
+	Head struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"head"`
+	Branch string `json:"branch"`
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":       "Coverage uploaded successfully",
-		"project_id":    project.ID,
-		"build_id":      build.ID,
-		"job_id":        job.ID,
-		"coverage_rate": coverageRate,
-	})
+// CoverallsSourceFile is a source file of a Coveralls upload
+type CoverallsSourceFile struct {
+	Name         string              `json:"name"`
+	Source       string              `json:"source"`
+	SourceDigest string              `json:"source_digest"` // MD5 of the source, sent by clients that omit it
+	Coverage     []interface{}       `json:"coverage"`
+	Branches     []int               `json:"branches"` // Flattened [line, block, branch, hits] quadruples
+	Functions    []UploadFunction    `json:"functions"`
+	Contexts     map[string][]string `json:"contexts"`   // Line number -> labels of the tests that hit it
+	SourceMap    json.RawMessage     `json:"source_map"` // Source map of a bundled file, as an object or a string
 }
 
-// CoverallsUpload represents the Coveralls JSON format
-type CoverallsUpload struct {
-	RepoToken     string `json:"repo_token" binding:"required"`
-	ServiceName   string `json:"service_name"`
-	ServiceNumber string `json:"service_number"`
-	ServiceJobID  string `json:"service_job_id"`
-	Git           *struct {
-		Head struct {
-			ID      string `json:"id"`
-			Message string `json:"message"`
-		} `json:"head"`
-		Branch string `json:"branch"`
-	} `json:"git"`
-	SourceFiles []struct {
-		Name     string        `json:"name"`
-		Source   string        `json:"source"`
-		Coverage []interface{} `json:"coverage"`
-	} `json:"source_files"`
+// UploadFunction is the coverage of a function of an uploaded source file.
+// It is a LibreCov extension to the Coveralls format.
+type UploadFunction struct {
+	Name      string `json:"name"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Hits      int    `json:"hits"`
 }
 
 // Upload handles coverage upload (Coveralls-compatible)
@@ -335,8 +236,14 @@ func (h *JobHandler) Upload(c *gin.Context) {
 	fmt.Printf("DEBUG: Upload called\n")
 	var upload CoverallsUpload
 
-	// First try to bind as direct JSON
-	if err := c.ShouldBindJSON(&upload); err != nil {
+	// Multipart uploads (goveralls format) carry the JSON in the "json" form
+	// field, next to optional source maps; read it before the body is consumed
+	if c.ContentType() == "multipart/form-data" {
+		if err := json.Unmarshal([]byte(c.PostForm("json")), &upload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format", "details": err.Error()})
+			return
+		}
+	} else if err := c.ShouldBindJSON(&upload); err != nil {
 		// If that fails, try to get JSON from form field "json" (goveralls format)
 		jsonStr := c.PostForm("json")
 		if jsonStr != "" {
@@ -350,6 +257,12 @@ func (h *JobHandler) Upload(c *gin.Context) {
 		}
 	}
 
+	h.storeUpload(c, &upload)
+}
+
+// storeUpload persists a parsed Coveralls upload as a new build and job and
+// writes the JSON response. It is shared by Upload and CreateJob.
+func (h *JobHandler) storeUpload(c *gin.Context, upload *CoverallsUpload) {
 	// Find project by token
 	var project models.Project
 	if err := h.db.Where("token = ?", upload.RepoToken).First(&project).Error; err != nil {
@@ -361,6 +274,12 @@ func (h *JobHandler) Upload(c *gin.Context) {
 		return
 	}
 
+	// Map bundled files back to the original sources developers edit
+	if err := remapUploadSourceMaps(c, upload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source map", "details": err.Error()})
+		return
+	}
+
 	// Get or create build
 	var build models.Build
 	commitSHA := ""
@@ -378,11 +297,14 @@ func (h *JobHandler) Upload(c *gin.Context) {
 	h.db.Model(&models.Build{}).Where("project_id = ?", project.ID).Select("COALESCE(MAX(build_num), 0)").Scan(&maxBuildNum)
 
 	build = models.Build{
-		ProjectID: project.ID,
-		BuildNum:  maxBuildNum + 1,
-		Branch:    branch,
-		CommitSHA: commitSHA,
-		CommitMsg: commitMsg,
+		ProjectID:       project.ID,
+		BuildNum:        maxBuildNum + 1,
+		Branch:          branch,
+		CommitSHA:       commitSHA,
+		CommitMsg:       commitMsg,
+		ServiceName:     upload.ServiceName,
+		ServiceNumber:   upload.ServiceNumber,
+		ServiceBuildURL: upload.ServiceBuildURL,
 	}
 
 	if err := h.db.Create(&build).Error; err != nil {
@@ -397,8 +319,12 @@ func (h *JobHandler) Upload(c *gin.Context) {
 	}
 
 	job := models.Job{
-		BuildID:   build.ID,
-		JobNumber: jobNumber,
+		BuildID:       build.ID,
+		JobNumber:     jobNumber,
+		ServiceName:   upload.ServiceName,
+		ServiceNumber: upload.ServiceNumber,
+		ServiceJobURL: upload.ServiceJobURL,
+		FlagName:      upload.FlagName,
 	}
 
 	if err := h.db.Create(&job).Error; err != nil {
@@ -409,6 +335,10 @@ func (h *JobHandler) Upload(c *gin.Context) {
 	// Process source files and calculate coverage
 	totalLines := 0
 	coveredLines := 0
+	totalBranches := 0
+	coveredBranches := 0
+	totalFunctions := 0
+	coveredFunctions := 0
 
 	for _, sourceFile := range upload.SourceFiles {
 		fileLines := 0
@@ -436,14 +366,59 @@ func (h *JobHandler) Upload(c *gin.Context) {
 		// Store coverage as JSON string
 		coverageJSON, _ := json.Marshal(sourceFile.Coverage)
 
+		// Keep a digest of the source to detect unchanged files across builds
+		sourceDigest := sourceFile.SourceDigest
+		if sourceDigest == "" && sourceFile.Source != "" {
+			sourceDigest = fmt.Sprintf("%x", md5.Sum([]byte(sourceFile.Source)))
+		}
+
 		jobFile := models.JobFile{
 			JobID:        job.ID,
 			Name:         sourceFile.Name,
 			Source:       sourceFile.Source,
+			SourceDigest: sourceDigest,
 			Coverage:     string(coverageJSON),
 			CoverageRate: fileCoverageRate,
 		}
 
+		// Calculate branch coverage for this file, when reported
+		if fileBranches, fileBranchesCovered := countBranches(sourceFile.Branches); fileBranches > 0 {
+			totalBranches += fileBranches
+			coveredBranches += fileBranchesCovered
+
+			branchesJSON, _ := json.Marshal(sourceFile.Branches)
+			branchRate := coverageRate(fileBranchesCovered, fileBranches)
+			jobFile.Branches = string(branchesJSON)
+			jobFile.BranchCoverageRate = &branchRate
+		}
+
+		// Calculate function coverage for this file, when reported
+		if len(sourceFile.Functions) > 0 {
+			fileFunctionsCovered := 0
+			for _, fn := range sourceFile.Functions {
+				jobFile.Functions = append(jobFile.Functions, models.FileFunction{
+					Name:      fn.Name,
+					StartLine: fn.StartLine,
+					EndLine:   fn.EndLine,
+					Hits:      fn.Hits,
+				})
+				if fn.Hits > 0 {
+					fileFunctionsCovered++
+				}
+			}
+			totalFunctions += len(sourceFile.Functions)
+			coveredFunctions += fileFunctionsCovered
+
+			functionRate := coverageRate(fileFunctionsCovered, len(sourceFile.Functions))
+			jobFile.FunctionCoverageRate = &functionRate
+		}
+
+		// Keep the tests that hit each line, when reported
+		if len(sourceFile.Contexts) > 0 {
+			contextsJSON, _ := json.Marshal(sourceFile.Contexts)
+			jobFile.Contexts = string(contextsJSON)
+		}
+
 		if err := h.db.Create(&jobFile).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job file"})
 			return
@@ -451,21 +426,42 @@ func (h *JobHandler) Upload(c *gin.Context) {
 	}
 
 	// Calculate overall coverage rate
-	var coverageRate float64
-	if totalLines > 0 {
-		coverageRate = (float64(coveredLines) / float64(totalLines)) * 100
+	rate := coverageRate(coveredLines, totalLines)
+
+	// Calculate overall branch coverage rate, when reported
+	var branchCoverageRate *float64
+	if totalBranches > 0 {
+		branchRate := coverageRate(coveredBranches, totalBranches)
+		branchCoverageRate = &branchRate
+	}
+
+	// Calculate overall function coverage rate, when reported
+	var functionCoverageRate *float64
+	if totalFunctions > 0 {
+		functionRate := coverageRate(coveredFunctions, totalFunctions)
+		functionCoverageRate = &functionRate
 	}
 
 	// Update job with coverage rate
-	job.CoverageRate = coverageRate
+	job.CoverageRate = rate
+	job.BranchCoverageRate = branchCoverageRate
+	job.FunctionCoverageRate = functionCoverageRate
 	h.db.Save(&job)
 
 	// Update build with coverage rate
-	build.CoverageRate = coverageRate
+	build.CoverageRate = rate
+	build.BranchCoverageRate = branchCoverageRate
+	build.FunctionCoverageRate = functionCoverageRate
 	h.db.Save(&build)
 
+	// Check the build against the project's quality gate. The upload is kept
+	// when this fails, and the gate is evaluated again when its status is requested.
+	if err := evaluateQualityGate(h.db, &build); err != nil {
+		log.Printf("Failed to evaluate quality gate for build %d: %v", build.ID, err)
+	}
+
 	// Update project with latest coverage rate
-	project.CoverageRate = coverageRate
+	project.CoverageRate = rate
 	h.db.Save(&project)
 
 	c.JSON(http.StatusOK, gin.H{
@@ -473,7 +469,8 @@ func (h *JobHandler) Upload(c *gin.Context) {
 		"project_id":    project.ID,
 		"build_id":      build.ID,
 		"job_id":        job.ID,
-		"coverage_rate": coverageRate,
+		"coverage_rate": rate,
+		"gate_status":   newGateResult(&build).Status,
 	})
 }
 
@@ -505,7 +502,11 @@ func (h *FileHandler) Get(c *gin.Context) {
 	id := c.Param("id")
 
 	var file models.JobFile
-	if err := h.db.First(&file, id).Error; err != nil {
+	if err := h.db.Preload("Functions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("start_line, id")
+	}).Preload("SurvivingMutants", func(db *gorm.DB) *gorm.DB {
+		return db.Where("status = ?", MutantSurvived).Order("line, id")
+	}).First(&file, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		} else {
@@ -698,35 +699,3 @@ func NewWebhookHandler(db *gorm.DB) *WebhookHandler {
 func (h *WebhookHandler) HandleWebhook(c *gin.Context) {
 	c.JSON(http.StatusNotImplemented, gin.H{"message": "Not implemented yet"})
 }
-
-// BadgeHandler handles badge generation
-type BadgeHandler struct {
-	db *gorm.DB
-}
-
-// NewBadgeHandler creates a new badge handler
-func NewBadgeHandler(db *gorm.DB) *BadgeHandler {
-	return &BadgeHandler{db: db}
-}
-
-// GetBadge generates and returns a coverage badge
-func (h *BadgeHandler) GetBadge(c *gin.Context) {
-	id := c.Param("id")
-
-	var project models.Project
-	if err := h.db.Where("id = ?", id).First(&project).Error; err != nil {
-		c.String(http.StatusNotFound, "Project not found")
-		return
-	}
-
-	// Simple SVG badge
-	badge := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="20">
-		<rect width="100" height="20" fill="#555"/>
-		<rect x="50" width="50" height="20" fill="#4c1"/>
-		<text x="25" y="15" fill="#fff" font-family="Arial" font-size="11">coverage</text>
-		<text x="75" y="15" fill="#fff" font-family="Arial" font-size="11">%.1f%%</text>
-	</svg>`
-
-	c.Header("Content-Type", "image/svg+xml")
-	c.String(http.StatusOK, badge, project.CoverageRate)
-}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/Frantche/Librecov/backend/internal/parquet"
+	"github.com/gin-gonic/gin"
+)
+
+// historyColumn is a column of a history export and the SQL expression of its values
+type historyColumn struct {
+	parquet.Column
+	expr string
+}
+
+// historyDataset is a table of a history export, with a row per build, job or job file
+type historyDataset struct {
+	table   string
+	joins   []string
+	order   string
+	columns []historyColumn
+}
+
+// Columns shared by the history datasets, identifying the project and the build of a row.
+// Columns that are not keys are optional, as legacy rows may hold nulls that
+// would otherwise abort the export once it has started.
+var historyBuildColumns = []historyColumn{
+	{parquet.Column{Name: "project_id", Type: parquet.String}, "projects.id"},
+	{parquet.Column{Name: "project_name", Type: parquet.String}, "projects.name"},
+	{parquet.Column{Name: "build_id", Type: parquet.Int64}, "builds.id"},
+	{parquet.Column{Name: "build_num", Type: parquet.Int64, Optional: true}, "builds.build_num"},
+	{parquet.Column{Name: "branch", Type: parquet.String}, "builds.branch"},
+	{parquet.Column{Name: "commit_sha", Type: parquet.String}, "builds.commit_sha"},
+	{parquet.Column{Name: "created_at", Type: parquet.Timestamp, Optional: true}, "builds.created_at"},
+}
+
+// rateColumns are the coverage rate columns of a table
+func rateColumns(table string) []historyColumn {
+	return []historyColumn{
+		{parquet.Column{Name: "coverage_rate", Type: parquet.Double, Optional: true}, table + ".coverage_rate"},
+		{parquet.Column{Name: "branch_coverage_rate", Type: parquet.Double, Optional: true}, table + ".branch_coverage_rate"},
+		{parquet.Column{Name: "function_coverage_rate", Type: parquet.Double, Optional: true}, table + ".function_coverage_rate"},
+	}
+}
+
+// historyDatasets are the datasets of the history exports, by name
+var historyDatasets = map[string]historyDataset{
+	"builds": {
+		table: "builds",
+		joins: []string{"JOIN projects ON projects.id = builds.project_id AND projects.deleted_at IS NULL"},
+		order: "builds.created_at, builds.id",
+		columns: concatColumns(historyBuildColumns, rateColumns("builds"), []historyColumn{
+			{parquet.Column{Name: "patch_coverage_rate", Type: parquet.Double, Optional: true}, "builds.patch_coverage_rate"},
+			{parquet.Column{Name: "gate_status", Type: parquet.String}, "builds.gate_status"},
+		}),
+	},
+	"jobs": {
+		table: "jobs",
+		joins: []string{
+			"JOIN builds ON builds.id = jobs.build_id AND builds.deleted_at IS NULL",
+			"JOIN projects ON projects.id = builds.project_id AND projects.deleted_at IS NULL",
+		},
+		order: "builds.created_at, builds.id, jobs.id",
+		columns: concatColumns(historyBuildColumns, []historyColumn{
+			{parquet.Column{Name: "job_id", Type: parquet.Int64}, "jobs.id"},
+			{parquet.Column{Name: "job_number", Type: parquet.String}, "jobs.job_number"},
+			{parquet.Column{Name: "flag_name", Type: parquet.String}, "jobs.flag_name"},
+		}, rateColumns("jobs")),
+	},
+	"files": {
+		table: "job_files",
+		joins: []string{
+			"JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL",
+			"JOIN builds ON builds.id = jobs.build_id AND builds.deleted_at IS NULL",
+			"JOIN projects ON projects.id = builds.project_id AND projects.deleted_at IS NULL",
+		},
+		order: "builds.created_at, builds.id, jobs.id, job_files.name",
+		columns: concatColumns(historyBuildColumns, []historyColumn{
+			{parquet.Column{Name: "job_id", Type: parquet.Int64}, "jobs.id"},
+			{parquet.Column{Name: "flag_name", Type: parquet.String}, "jobs.flag_name"},
+			{parquet.Column{Name: "file", Type: parquet.String}, "job_files.name"},
+		}, rateColumns("job_files")),
+	},
+}
+
+func concatColumns(groups ...[]historyColumn) []historyColumn {
+	var columns []historyColumn
+	for _, group := range groups {
+		columns = append(columns, group...)
+	}
+	return columns
+}
+
+// historyWriter writes the rows of a history export
+type historyWriter interface {
+	Write(row []any) error
+	Close() error
+}
+
+// ExportHistory streams the coverage history of a project, or of every project
+// from the admin route, as CSV or Parquet
+//
+//	@Summary		Export coverage history
+//	@Description	Stream the builds, jobs or per-file coverage rates of a project over a date range as CSV or Parquet, for data warehouses. Rows are ordered by build date.
+//	@Tags			export
+//	@Produce		text/csv
+//	@Produce		application/vnd.apache.parquet
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			dataset	query		string	false	"builds (default), jobs or files"
+//	@Param			format	query		string	false	"csv (default) or parquet"
+//	@Param			from	query		string	false	"Start date, inclusive (YYYY-MM-DD or RFC 3339)"
+//	@Param			to		query		string	false	"End date, inclusive (YYYY-MM-DD or RFC 3339)"
+//	@Success		200		{file}		file
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/history/export [get]
+//	@Router			/api/v1/admin/history/export [get]
+func (h *ExportHandler) ExportHistory(c *gin.Context) {
+	datasetName := c.DefaultQuery("dataset", "builds")
+	dataset, ok := historyDatasets[datasetName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown dataset, expected one of: builds, jobs, files"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "parquet" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown format, expected csv or parquet"})
+		return
+	}
+
+	query := h.db.Table(dataset.table).Where(dataset.table + ".deleted_at IS NULL")
+	for _, join := range dataset.joins {
+		query = query.Joins(join)
+	}
+
+	if from := c.Query("from"); from != "" {
+		start, _, err := parseHistoryDate(from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+		query = query.Where("builds.created_at >= ?", start)
+	}
+	if to := c.Query("to"); to != "" {
+		start, dateOnly, err := parseHistoryDate(to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+			return
+		}
+		if dateOnly {
+			query = query.Where("builds.created_at < ?", start.AddDate(0, 0, 1))
+		} else {
+			query = query.Where("builds.created_at <= ?", start)
+		}
+	}
+
+	if projectID := c.Param("id"); projectID != "" {
+		var project models.Project
+		if err := h.db.Select("id").Where("id = ?", projectID).First(&project).Error; err != nil {
+			respondBuildLookupError(c, err, "Project not found")
+			return
+		}
+		query = query.Where("builds.project_id = ?", project.ID)
+	}
+
+	selects := make([]string, len(dataset.columns))
+	columns := make([]parquet.Column, len(dataset.columns))
+	for i, column := range dataset.columns {
+		selects[i] = column.expr
+		columns[i] = column.Column
+	}
+
+	rows, err := query.Select(selects).Order(dataset.order).Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch history"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, datasetName, format))
+	var writer historyWriter
+	if format == "parquet" {
+		c.Header("Content-Type", "application/vnd.apache.parquet")
+		writer = parquet.NewWriter(c.Writer, columns)
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		csvWriter, err := newCSVHistoryWriter(c.Writer, columns)
+		if err != nil {
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write history"})
+			return
+		}
+		writer = csvWriter
+	}
+	c.Status(http.StatusOK)
+
+	// Headers are sent, so errors past this point can only cut the export short
+	if err := streamHistory(rows, columns, writer); err != nil {
+		c.Error(err)
+	}
+}
+
+// streamHistory writes the rows of a history query as they are read
+func streamHistory(rows *sql.Rows, columns []parquet.Column, writer historyWriter) error {
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		switch column.Type {
+		case parquet.Int64:
+			dest[i] = new(sql.NullInt64)
+		case parquet.Double:
+			dest[i] = new(sql.NullFloat64)
+		case parquet.Timestamp:
+			dest[i] = new(sql.NullTime)
+		default:
+			dest[i] = new(sql.NullString)
+		}
+	}
+
+	row := make([]any, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range dest {
+			row[i] = nil
+			switch v := v.(type) {
+			case *sql.NullInt64:
+				if v.Valid {
+					row[i] = v.Int64
+				}
+			case *sql.NullFloat64:
+				if v.Valid {
+					row[i] = v.Float64
+				}
+			case *sql.NullTime:
+				if v.Valid {
+					row[i] = v.Time
+				}
+			case *sql.NullString:
+				// Null strings are exported as empty strings
+				row[i] = v.String
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// parseHistoryDate parses a date or an RFC 3339 timestamp, and reports whether it was a date
+func parseHistoryDate(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+// csvHistoryWriter writes history rows as CSV, with a header row. Nulls are empty.
+type csvHistoryWriter struct {
+	w *csv.Writer
+}
+
+func newCSVHistoryWriter(w io.Writer, columns []parquet.Column) (*csvHistoryWriter, error) {
+	writer := &csvHistoryWriter{w: csv.NewWriter(w)}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := writer.w.Write(header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *csvHistoryWriter) Write(row []any) error {
+	record := make([]string, len(row))
+	for i, v := range row {
+		switch v := v.(type) {
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			record[i] = v.UTC().Format(time.RFC3339)
+		case string:
+			record[i] = v
+		}
+	}
+	return w.w.Write(record)
+}
+
+func (w *csvHistoryWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Hotspot is the risk of a file, or of a directory when grouped
+type Hotspot struct {
+	Name           string  `json:"name"`
+	Path           string  `json:"path"`
+	Type           string  `json:"type"` // dir or file
+	Files          int     `json:"files"`
+	RelevantLines  int     `json:"relevant_lines"`
+	UncoveredLines int     `json:"uncovered_lines"`
+	CoverageRate   float64 `json:"coverage_rate"`
+	Churn          int     `json:"churn"` // Number of source changes across the analyzed builds
+	RiskScore      float64 `json:"risk_score"`
+}
+
+// HotspotReport ranks the riskiest files or directories of a project
+type HotspotReport struct {
+	ProjectID string    `json:"project_id"`
+	Branch    string    `json:"branch"`
+	Path      string    `json:"path"`
+	Group     string    `json:"group"`
+	Builds    int       `json:"builds"` // Number of builds analyzed
+	Hotspots  []Hotspot `json:"hotspots"`
+}
+
+// GetHotspots ranks the files of a project by risk
+//
+//	@Summary		Risk hotspots
+//	@Description	Rank the files of a project, or the directories under a path, by a risk score combining uncovered lines, source churn across recent builds and file size
+//	@Tags			projects
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			branch	query		string	false	"Branch (defaults to the project's current branch)"
+//	@Param			builds	query		int		false	"Number of recent builds used to measure churn (default 20, max 200)"
+//	@Param			path	query		string	false	"Only rank files under this directory"
+//	@Param			group	query		string	false	"file (default) or dir to rank the direct subdirectories of path"
+//	@Param			limit	query		int		false	"Maximum number of hotspots (default 20, max 500)"
+//	@Success		200		{object}	HotspotReport
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/hotspots [get]
+func (h *ProjectHandler) GetHotspots(c *gin.Context) {
+	projectID := c.Param("id")
+
+	builds, err := strconv.Atoi(c.DefaultQuery("builds", "20"))
+	if err != nil || builds < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid builds"})
+		return
+	}
+	builds = min(builds, 200)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	limit = min(limit, 500)
+
+	group := c.DefaultQuery("group", "file")
+	if group != "file" && group != "dir" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group must be file or dir"})
+		return
+	}
+
+	var project models.Project
+	if err := h.db.Where("id = ?", projectID).First(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
+		}
+		return
+	}
+
+	branch := c.DefaultQuery("branch", project.CurrentBranch)
+	dir := normalizeTreePath(c.Query("path"))
+
+	hotspots, analyzed, err := computeHotspots(h.db, project.ID, branch, builds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze builds"})
+		return
+	}
+
+	hotspots = filterHotspots(hotspots, dir)
+	if group == "dir" {
+		hotspots = groupHotspots(hotspots, dir)
+	}
+	rankHotspots(hotspots)
+	if len(hotspots) > limit {
+		hotspots = hotspots[:limit]
+	}
+
+	c.JSON(http.StatusOK, HotspotReport{
+		ProjectID: project.ID,
+		Branch:    branch,
+		Path:      dir,
+		Group:     group,
+		Builds:    analyzed,
+		Hotspots:  hotspots,
+	})
+}
+
+// computeHotspots scores the files of the latest build of a branch, measuring
+// churn over the given number of recent builds. It also returns the number of
+// builds analyzed.
+func computeHotspots(db *gorm.DB, projectID, branch string, limit int) ([]Hotspot, int, error) {
+	builds, err := recentBuilds(db, projectID, branch, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	snapshots, err := loadFileSnapshots(db, builds)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hotspots := []Hotspot{}
+	for name, perBuild := range snapshots {
+		latest := perBuild[len(perBuild)-1]
+		if latest == nil {
+			// Files missing from the latest build no longer exist
+			continue
+		}
+
+		relevant, covered := (&fileCoverage{Lines: latest.Lines}).counts()
+		hotspot := Hotspot{
+			Name:           name,
+			Path:           normalizeTreePath(name),
+			Type:           "file",
+			Files:          1,
+			RelevantLines:  relevant,
+			UncoveredLines: relevant - covered,
+			CoverageRate:   coverageRate(covered, relevant),
+			Churn:          countSourceChanges(perBuild),
+		}
+		hotspot.RiskScore = riskScore(hotspot.UncoveredLines, hotspot.Churn, hotspot.RelevantLines)
+		hotspots = append(hotspots, hotspot)
+	}
+
+	return hotspots, len(builds), nil
+}
+
+// countSourceChanges counts how many times a file changed between the builds
+// that reported it
+func countSourceChanges(snapshots []*fileSnapshot) int {
+	changes := 0
+	var previous *fileSnapshot
+	for _, snap := range snapshots {
+		if snap == nil {
+			continue
+		}
+		if previous != nil && !sameFileVersion(previous, snap) {
+			changes++
+		}
+		previous = snap
+	}
+	return changes
+}
+
+// riskScore weighs uncovered lines by how often the file changes and by its
+// size. Fully covered files carry no risk; each source change adds the
+// uncovered lines once more, and larger files get a logarithmic boost since
+// untested code is harder to reason about there.
+func riskScore(uncovered, churn, relevant int) float64 {
+	score := float64(uncovered) * float64(1+churn) * (1 + math.Log10(float64(1+relevant)))
+	return math.Round(score*100) / 100
+}
+
+// filterHotspots keeps the files under a directory
+func filterHotspots(hotspots []Hotspot, dir string) []Hotspot {
+	if dir == "" {
+		return hotspots
+	}
+	prefix := dir + "/"
+	filtered := []Hotspot{}
+	for _, h := range hotspots {
+		if strings.HasPrefix(h.Path, prefix) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+// groupHotspots rolls file hotspots up into the direct children of a directory.
+// Scores and churn add up, so a directory ranks by the total risk it contains.
+func groupHotspots(hotspots []Hotspot, dir string) []Hotspot {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	children := make(map[string]*Hotspot)
+	for _, h := range hotspots {
+		rel := strings.TrimPrefix(h.Path, prefix)
+		childName, nodeType := rel, "file"
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			childName, nodeType = rel[:i], "dir"
+		}
+
+		node, ok := children[childName]
+		if !ok {
+			node = &Hotspot{Name: childName, Path: prefix + childName, Type: nodeType}
+			children[childName] = node
+		}
+		node.Files += h.Files
+		node.RelevantLines += h.RelevantLines
+		node.UncoveredLines += h.UncoveredLines
+		node.Churn += h.Churn
+		node.RiskScore += h.RiskScore
+	}
+
+	grouped := make([]Hotspot, 0, len(children))
+	for _, node := range children {
+		node.CoverageRate = coverageRate(node.RelevantLines-node.UncoveredLines, node.RelevantLines)
+		node.RiskScore = math.Round(node.RiskScore*100) / 100
+		grouped = append(grouped, *node)
+	}
+	return grouped
+}
+
+// rankHotspots sorts hotspots by risk, highest first
+func rankHotspots(hotspots []Hotspot) {
+	sort.Slice(hotspots, func(i, j int) bool {
+		if hotspots[i].RiskScore != hotspots[j].RiskScore {
+			return hotspots[i].RiskScore > hotspots[j].RiskScore
+		}
+		if hotspots[i].UncoveredLines != hotspots[j].UncoveredLines {
+			return hotspots[i].UncoveredLines > hotspots[j].UncoveredLines
+		}
+		return hotspots[i].Path < hotspots[j].Path
+	})
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetHotspots(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Hotspots", Token: "hotspot-token", CurrentBranch: "main"}
+	db.Create(&project)
+
+	createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{
+		"a.go": `[1, 0, null]`, "b.go": `[1, 1]`, "pkg/c.go": `[0, 0]`, "gone.go": `[0]`,
+	})
+	createTestBuild(t, db, project.ID, 2, "main", "c2", map[string]string{
+		"a.go": `[1, 0, 0]`, "b.go": `[1, 1]`, "pkg/c.go": `[0, 0]`,
+	})
+	createTestBuild(t, db, project.ID, 3, "main", "c3", map[string]string{
+		"a.go": `[1, 0, 0]`, "b.go": `[1, 1]`, "pkg/c.go": `[0, 0]`,
+	})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	handler := NewProjectHandler(db)
+	router.GET("/projects/:id/hotspots", handler.GetHotspots)
+
+	req := httptest.NewRequest("GET", "/projects/"+project.ID+"/hotspots", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var report HotspotReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if report.Builds != 3 || len(report.Hotspots) != 3 {
+		t.Fatalf("Expected 3 files from 3 builds, got %+v", report)
+	}
+	top := report.Hotspots[0]
+	if top.Name != "a.go" || top.Churn != 1 || top.UncoveredLines != 2 {
+		t.Errorf("Expected a.go to rank first with churn 1, got %+v", top)
+	}
+	if report.Hotspots[1].Name != "pkg/c.go" || report.Hotspots[1].Churn != 0 {
+		t.Errorf("Expected pkg/c.go second without churn, got %+v", report.Hotspots[1])
+	}
+	if last := report.Hotspots[2]; last.Name != "b.go" || last.RiskScore != 0 {
+		t.Errorf("Expected fully covered b.go to carry no risk, got %+v", last)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/projects/"+project.ID+"/hotspots?group=dir", nil)
+	router.ServeHTTP(w, req)
+
+	json.Unmarshal(w.Body.Bytes(), &report)
+	var pkg *Hotspot
+	for i := range report.Hotspots {
+		if report.Hotspots[i].Path == "pkg" {
+			pkg = &report.Hotspots[i]
+		}
+	}
+	if pkg == nil || pkg.Type != "dir" || pkg.Files != 1 || pkg.UncoveredLines != 2 {
+		t.Errorf("Expected a pkg directory hotspot, got %+v", report.Hotspots)
+	}
+}
+
+func TestRiskScore(t *testing.T) {
+	if riskScore(0, 5, 100) != 0 {
+		t.Error("Expected fully covered files to carry no risk")
+	}
+	if riskScore(10, 1, 50) <= riskScore(10, 0, 50) {
+		t.Error("Expected churn to raise the risk")
+	}
+	if riskScore(10, 0, 500) <= riskScore(10, 0, 20) {
+		t.Error("Expected larger files to rank higher")
+	}
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"archive/zip"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+)
+
+// htmlReportRoot is the directory of the HTML report in its zip archive
+const htmlReportRoot = "coverage-report"
+
+// htmlReportStyle is inlined in every page so that the report works offline
+const htmlReportStyle = `
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292f; }
+h1 { font-size: 1.5em; margin-bottom: .2em; }
+.meta { color: #57606a; margin-bottom: 1.5em; }
+table { border-collapse: collapse; }
+th, td { padding: .25em .75em; text-align: left; }
+th { border-bottom: 2px solid #d0d7de; }
+.summary td, .tree td { border-bottom: 1px solid #eaeef2; }
+.num { text-align: right; font-variant-numeric: tabular-nums; }
+.dir { font-weight: 600; }
+.high { color: #1a7f37; } .medium { color: #9a6700; } .low { color: #cf222e; }
+.source { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 12px; width: 100%; }
+.source td { padding: 0 .75em; white-space: pre; }
+.source .line, .source .hits, .source .branches { color: #57606a; text-align: right; user-select: none; }
+tr.covered td.code { background: #dafbe1; }
+tr.uncovered td.code { background: #ffebe9; }
+tr.partial td.code { background: #fff8c5; }
+a { color: #0969da; text-decoration: none; }
+a:hover { text-decoration: underline; }
+`
+
+var htmlIndexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"indent": func(depth int) template.CSS {
+		return template.CSS(fmt.Sprintf("padding-left: %.1fem", 0.75+1.5*float64(depth)))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>{{.Style}}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">{{.Meta}}</div>
+<table class="summary">
+<tr><th></th><th class="num">Covered</th><th class="num">Total</th><th class="num">Coverage</th></tr>
+{{range .Totals}}<tr><td>{{.Name}}</td><td class="num">{{.Covered}}</td><td class="num">{{.Total}}</td><td class="num {{.Class}}">{{printf "%.2f" .Rate}}%</td></tr>
+{{end}}</table>
+<h2>Files</h2>
+<table class="tree">
+<tr><th>Path</th><th class="num">Lines</th><th class="num">Covered</th><th class="num">Branches</th><th class="num">Coverage</th></tr>
+{{range .Rows}}<tr{{if .Dir}} class="dir"{{end}}><td style="{{indent .Depth}}">{{if .Link}}<a href="{{.Link}}">{{.Name}}</a>{{else}}{{.Name}}/{{end}}</td><td class="num">{{.Relevant}}</td><td class="num">{{.Covered}}</td><td class="num">{{if .Branches}}{{.CoveredBranches}}/{{.Branches}}{{end}}</td><td class="num {{.Class}}">{{printf "%.2f" .Rate}}%</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var htmlFileTemplate = template.Must(template.New("file").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} - {{.Title}}</title>
+<style>{{.Style}}</style>
+</head>
+<body>
+<div class="meta"><a href="{{.IndexLink}}">{{.Title}}</a></div>
+<h1>{{.Name}}</h1>
+<div class="meta"><span class="{{.Class}}">{{printf "%.2f" .Rate}}%</span> of {{.Relevant}} lines covered{{if .Branches}}, {{.CoveredBranches}} of {{.Branches}} branches{{end}}{{if .Functions}}, {{.CoveredFunctions}} of {{.Functions}} functions{{end}}</div>
+<table class="source">
+{{range .Lines}}<tr id="L{{.Number}}"{{if .Class}} class="{{.Class}}"{{end}}><td class="line">{{.Number}}</td><td class="hits">{{.Hits}}</td><td class="branches">{{.Branches}}</td><td class="code">{{.Source}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// htmlTotal is a row of the summary of the index page
+type htmlTotal struct {
+	Name           string
+	Covered, Total int
+	Rate           float64
+	Class          string
+}
+
+// htmlTreeRow is a directory or a file of the index page
+type htmlTreeRow struct {
+	Name                      string
+	Depth                     int
+	Dir                       bool
+	Link                      string
+	Relevant, Covered         int
+	Branches, CoveredBranches int
+	Rate                      float64
+	Class                     string
+}
+
+// htmlSourceLine is an annotated source line of a file page
+type htmlSourceLine struct {
+	Number   int
+	Hits     string
+	Branches string
+	Class    string
+	Source   string
+}
+
+// writeHTMLReport writes a zip archive with a static HTML report: an index with
+// totals and the directory tree, and a page per file with its annotated source
+func writeHTMLReport(w io.Writer, export *coverageExport) error {
+	archive := zip.NewWriter(w)
+
+	title := fmt.Sprintf("%s build #%d", export.Project.Name, export.Build.BuildNum)
+	if export.Job != nil {
+		title += " job " + export.Job.JobNumber
+	}
+	low, high := badgeThresholds(&export.Project)
+	rateClass := func(rate float64) string {
+		switch {
+		case rate >= high:
+			return "high"
+		case rate >= low:
+			return "medium"
+		default:
+			return "low"
+		}
+	}
+	meta := fmt.Sprintf("Commit %s on %s, %s", export.Build.CommitSHA, export.Build.Branch, export.Build.CreatedAt.UTC().Format("2006-01-02 15:04 UTC"))
+
+	var rows []htmlTreeRow
+	dirRows := make(map[string]int) // Directory to row index
+	lines, branches, functions := htmlTotal{Name: "Lines"}, htmlTotal{Name: "Branches"}, htmlTotal{Name: "Functions"}
+	for _, name := range sortedFileNames(export.Files) {
+		file := export.Files[name]
+		relevant, covered := file.counts()
+		rate := coverageRate(covered, relevant)
+		totalBranches, coveredBranches := file.branchCounts()
+		coveredFunctions := 0
+		for _, fn := range export.Functions[name] {
+			if fn.Hits > 0 {
+				coveredFunctions++
+			}
+		}
+
+		page := htmlReportPage(name)
+		clean := strings.TrimSuffix(page, ".html")
+		dirs := strings.Split(clean, "/")
+		dirs = dirs[:len(dirs)-1]
+		for depth := range dirs {
+			dir := strings.Join(dirs[:depth+1], "/")
+			i, ok := dirRows[dir]
+			if !ok {
+				i = len(rows)
+				dirRows[dir] = i
+				rows = append(rows, htmlTreeRow{Name: dirs[depth], Depth: depth, Dir: true})
+			}
+			rows[i].Relevant += relevant
+			rows[i].Covered += covered
+			rows[i].Branches += totalBranches
+			rows[i].CoveredBranches += coveredBranches
+		}
+		rows = append(rows, htmlTreeRow{
+			Name: path.Base(clean), Depth: len(dirs), Link: "files/" + page,
+			Relevant: relevant, Covered: covered, Branches: totalBranches, CoveredBranches: coveredBranches,
+			Rate: rate, Class: rateClass(rate),
+		})
+
+		lines.Total += relevant
+		lines.Covered += covered
+		branches.Total += totalBranches
+		branches.Covered += coveredBranches
+		functions.Total += len(export.Functions[name])
+		functions.Covered += coveredFunctions
+
+		f, err := archive.Create(htmlReportRoot + "/files/" + page)
+		if err != nil {
+			return err
+		}
+		err = htmlFileTemplate.Execute(f, map[string]interface{}{
+			"Title":            title,
+			"Style":            template.CSS(htmlReportStyle),
+			"IndexLink":        strings.Repeat("../", strings.Count(page, "/")+1) + "index.html",
+			"Name":             name,
+			"Rate":             rate,
+			"Class":            rateClass(rate),
+			"Relevant":         relevant,
+			"Branches":         totalBranches,
+			"CoveredBranches":  coveredBranches,
+			"Functions":        len(export.Functions[name]),
+			"CoveredFunctions": coveredFunctions,
+			"Lines":            htmlSourceLines(file),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, i := range dirRows {
+		rows[i].Rate = coverageRate(rows[i].Covered, rows[i].Relevant)
+		rows[i].Class = rateClass(rows[i].Rate)
+	}
+
+	// Branches and functions are only summarized when the reports had some
+	totals := []htmlTotal{lines}
+	if branches.Total > 0 {
+		totals = append(totals, branches)
+	}
+	if functions.Total > 0 {
+		totals = append(totals, functions)
+	}
+	for i := range totals {
+		totals[i].Rate = coverageRate(totals[i].Covered, totals[i].Total)
+		totals[i].Class = rateClass(totals[i].Rate)
+	}
+
+	f, err := archive.Create(htmlReportRoot + "/index.html")
+	if err != nil {
+		return err
+	}
+	err = htmlIndexTemplate.Execute(f, map[string]interface{}{
+		"Title":  title,
+		"Meta":   meta,
+		"Style":  template.CSS(htmlReportStyle),
+		"Totals": totals,
+		"Rows":   rows,
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// htmlReportPage returns the path of the page of a file, relative to the files
+// directory of the report. Paths cannot climb out of the report.
+func htmlReportPage(name string) string {
+	return normalizeTreePath(path.Clean("/"+name)) + ".html"
+}
+
+// htmlSourceLines annotates the source of a file with its hits and branches
+func htmlSourceLines(file *fileCoverage) []htmlSourceLine {
+	var source []string
+	if file.Source != "" {
+		source = strings.Split(strings.TrimSuffix(file.Source, "\n"), "\n")
+	}
+	branches := file.lineBranches()
+
+	lines := make([]htmlSourceLine, max(len(source), len(file.Lines)))
+	for i := range lines {
+		line := htmlSourceLine{Number: i + 1}
+		if i < len(source) {
+			line.Source = source[i]
+		}
+		if hits := file.hitsAt(i + 1); hits != lineNotRelevant {
+			line.Hits = fmt.Sprint(hits)
+			line.Class = "covered"
+			if hits == 0 {
+				line.Class = "uncovered"
+			}
+		}
+		if counts, ok := branches[i+1]; ok {
+			line.Branches = fmt.Sprintf("%d/%d", counts[1], counts[0])
+			if line.Class == "covered" && counts[1] < counts[0] {
+				line.Class = "partial"
+			}
+		}
+		lines[i] = line
+	}
+	return lines
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Test case statuses
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestError   = "error"
+	TestSkipped = "skipped"
+)
+
+// junitSuite is a <testsuite> element. Suites may be nested.
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Time   string       `xml:"time,attr"`
+	Cases  []junitCase  `xml:"testcase"`
+	Suites []junitSuite `xml:"testsuite"`
+}
+
+// junitCase is a <testcase> element
+type junitCase struct {
+	Name      string       `xml:"name,attr"`
+	ClassName string       `xml:"classname,attr"`
+	Time      string       `xml:"time,attr"`
+	Failure   *junitResult `xml:"failure"`
+	Error     *junitResult `xml:"error"`
+	Skipped   *junitResult `xml:"skipped"`
+}
+
+// junitResult is a <failure>, <error> or <skipped> element
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// TestUploadResult summarizes a JUnit upload
+type TestUploadResult struct {
+	JobID    uint    `json:"job_id"`
+	Suites   int     `json:"suites"`
+	Tests    int     `json:"tests"`
+	Failed   int     `json:"failed"`
+	Skipped  int     `json:"skipped"`
+	Duration float64 `json:"duration"`
+}
+
+// TestResult is a test case of a build
+type TestResult struct {
+	JobID     uint    `json:"job_id"`
+	Suite     string  `json:"suite"`
+	ClassName string  `json:"class_name"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Duration  float64 `json:"duration"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// BuildTests summarizes the test results of a build next to its coverage
+type BuildTests struct {
+	BuildID      uint         `json:"build_id"`
+	Tests        int          `json:"tests"`
+	Passed       int          `json:"passed"`
+	Failed       int          `json:"failed"`
+	Errors       int          `json:"errors"`
+	Skipped      int          `json:"skipped"`
+	Duration     float64      `json:"duration"`
+	CoverageRate float64      `json:"coverage_rate"`
+	Slowest      []TestResult `json:"slowest"`
+	Failing      []TestResult `json:"failing"` // Failed tests and errors
+}
+
+// UploadJUnit stores the JUnit XML test results of a job
+//
+//	@Summary		Upload JUnit test results
+//	@Description	Upload a JUnit XML report for a job created by a coverage upload. The report is sent as the request body or as the "file" form field, and replaces previous results of the job.
+//	@Tags			coverage
+//	@Accept			xml
+//	@Produce		json
+//	@Param			repo_token	query		string	true	"Project repo token"
+//	@Param			job_id		query		int		true	"Job ID returned by the coverage upload"
+//	@Success		200			{object}	TestUploadResult
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/upload/junit [post]
+func (h *JobHandler) UploadJUnit(c *gin.Context) {
+	upload, ok := h.openReportUpload(c)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+
+	suites, err := parseJUnitXML(upload.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JUnit report", "details": err.Error()})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// A new report replaces the previous results of the job
+		if err := tx.Unscoped().
+			Where("test_suite_id IN (?)", tx.Model(&models.TestSuite{}).Unscoped().Select("id").Where("job_id = ?", upload.Job.ID)).
+			Delete(&models.TestCase{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("job_id = ?", upload.Job.ID).Delete(&models.TestSuite{}).Error; err != nil {
+			return err
+		}
+		for i := range suites {
+			suites[i].JobID = upload.Job.ID
+			if err := tx.Create(&suites[i]).Error; err != nil {
+				return err
+			}
+		}
+		return updateTestSummary(tx, &upload.Job)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store test results"})
+		return
+	}
+
+	result := TestUploadResult{JobID: upload.Job.ID, Suites: len(suites)}
+	for _, s := range suites {
+		result.Tests += s.Tests
+		result.Failed += s.Failures + s.Errors
+		result.Skipped += s.Skipped
+		result.Duration += s.Duration
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Tests returns the test results of a build
+//
+//	@Summary		Build test results
+//	@Description	Get the test counts, slowest tests and failing tests of a build, next to its coverage
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Build ID"
+//	@Param			limit	query		int		false	"Number of slowest tests (default 10, max 100)"
+//	@Success		200		{object}	BuildTests
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/tests [get]
+func (h *BuildHandler) Tests(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid build ID"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	limit = min(limit, 100)
+
+	var build models.Build
+	if err := h.db.First(&build, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	var results []TestResult
+	if err := h.db.Table("test_cases").
+		Select("test_suites.job_id, test_suites.name AS suite, test_cases.class_name, test_cases.name, test_cases.status, test_cases.duration, test_cases.message").
+		Joins("JOIN test_suites ON test_suites.id = test_cases.test_suite_id AND test_suites.deleted_at IS NULL").
+		Joins("JOIN jobs ON jobs.id = test_suites.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ? AND test_cases.deleted_at IS NULL", build.ID).
+		Order("test_cases.id").
+		Scan(&results).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test results"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summarizeTests(&build, results, limit))
+}
+
+// summarizeTests counts the test results of a build and picks its slowest and failing tests
+func summarizeTests(build *models.Build, results []TestResult, limit int) BuildTests {
+	summary := BuildTests{
+		BuildID:      build.ID,
+		Tests:        len(results),
+		CoverageRate: build.CoverageRate,
+		Slowest:      []TestResult{},
+		Failing:      []TestResult{},
+	}
+
+	// Suite times include setup and teardown, so the duration is the one stored
+	// from them on upload rather than the sum of the test case times
+	if build.TestDuration != nil {
+		summary.Duration = *build.TestDuration
+	}
+
+	for _, r := range results {
+		switch r.Status {
+		case TestPassed:
+			summary.Passed++
+		case TestFailed:
+			summary.Failed++
+		case TestError:
+			summary.Errors++
+		case TestSkipped:
+			summary.Skipped++
+		}
+		if r.Status == TestFailed || r.Status == TestError {
+			summary.Failing = append(summary.Failing, r)
+		}
+	}
+
+	slowest := append([]TestResult(nil), results...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].Duration > slowest[j].Duration })
+	for _, r := range slowest {
+		if len(summary.Slowest) == limit || r.Status == TestSkipped {
+			break
+		}
+		summary.Slowest = append(summary.Slowest, r)
+	}
+
+	return summary
+}
+
+// updateTestSummary recomputes the test counts of a job from its suites, then
+// those of its build from all of its jobs
+func updateTestSummary(db *gorm.DB, job *models.Job) error {
+	type testTotals struct {
+		Tests    int
+		Failed   int
+		Skipped  int
+		Duration float64
+		Suites   int
+	}
+	sumSuites := func(query *gorm.DB) (testTotals, error) {
+		var totals testTotals
+		err := query.Select("COALESCE(SUM(test_suites.tests), 0) AS tests, " +
+			"COALESCE(SUM(test_suites.failures + test_suites.errors), 0) AS failed, " +
+			"COALESCE(SUM(test_suites.skipped), 0) AS skipped, " +
+			"COALESCE(SUM(test_suites.duration), 0) AS duration, " +
+			"COUNT(test_suites.id) AS suites").
+			Where("test_suites.deleted_at IS NULL").
+			Scan(&totals).Error
+		return totals, err
+	}
+
+	jobTotals, err := sumSuites(db.Table("test_suites").Where("test_suites.job_id = ?", job.ID))
+	if err != nil {
+		return err
+	}
+	buildTotals, err := sumSuites(db.Table("test_suites").
+		Joins("JOIN jobs ON jobs.id = test_suites.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ?", job.BuildID))
+	if err != nil {
+		return err
+	}
+
+	summary := func(t testTotals) map[string]interface{} {
+		if t.Suites == 0 {
+			return map[string]interface{}{"test_count": nil, "tests_failed": nil, "tests_skipped": nil, "test_duration": nil}
+		}
+		return map[string]interface{}{"test_count": t.Tests, "tests_failed": t.Failed, "tests_skipped": t.Skipped, "test_duration": t.Duration}
+	}
+
+	if err := db.Model(&models.Job{}).Where("id = ?", job.ID).Updates(summary(jobTotals)).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.Build{}).Where("id = ?", job.BuildID).Updates(summary(buildTotals)).Error
+}
+
+// parseJUnitXML parses a JUnit XML report with either a <testsuites> or a
+// <testsuite> root. Nested suites are flattened, and suite counts are derived
+// from their test cases rather than trusted from the attributes.
+func parseJUnitXML(r io.Reader) ([]models.TestSuite, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no testsuites or testsuite element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var root junitSuite
+		switch start.Name.Local {
+		case "testsuites", "testsuite":
+			if err := decoder.DecodeElement(&root, &start); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected root element %q", start.Name.Local)
+		}
+
+		suites := []models.TestSuite{}
+		if start.Name.Local == "testsuite" {
+			flattenJUnitSuite(root, &suites)
+		} else {
+			for _, s := range root.Suites {
+				flattenJUnitSuite(s, &suites)
+			}
+		}
+		return suites, nil
+	}
+}
+
+// flattenJUnitSuite converts a suite and its nested suites, skipping suites without test cases
+func flattenJUnitSuite(suite junitSuite, suites *[]models.TestSuite) {
+	if len(suite.Cases) > 0 {
+		ts := models.TestSuite{Name: suite.Name}
+		for _, tc := range suite.Cases {
+			testCase := models.TestCase{
+				ClassName: tc.ClassName,
+				Name:      tc.Name,
+				Status:    TestPassed,
+				Duration:  parseJUnitTime(tc.Time),
+			}
+			switch {
+			case tc.Failure != nil:
+				testCase.Status = TestFailed
+				testCase.Message = junitMessage(tc.Failure)
+				ts.Failures++
+			case tc.Error != nil:
+				testCase.Status = TestError
+				testCase.Message = junitMessage(tc.Error)
+				ts.Errors++
+			case tc.Skipped != nil:
+				testCase.Status = TestSkipped
+				testCase.Message = junitMessage(tc.Skipped)
+				ts.Skipped++
+			}
+			ts.Tests++
+			ts.Duration += testCase.Duration
+			ts.Cases = append(ts.Cases, testCase)
+		}
+		// The time of a suite includes its nested suites, which are stored
+		// separately, so it only replaces the sum of its own cases when it has none
+		if suite.Time != "" && len(suite.Suites) == 0 {
+			ts.Duration = parseJUnitTime(suite.Time)
+		}
+		*suites = append(*suites, ts)
+	}
+
+	for _, nested := range suite.Suites {
+		flattenJUnitSuite(nested, suites)
+	}
+}
+
+// parseJUnitTime parses a duration in seconds. Some tools write thousands
+// separators; unparsable values count as zero.
+func parseJUnitTime(value string) float64 {
+	seconds, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(value), ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+	return seconds
+}
+
+// junitMessage returns the message of a result, falling back to the first line of its body
+func junitMessage(result *junitResult) string {
+	if result.Message != "" {
+		return result.Message
+	}
+	text := strings.TrimSpace(result.Text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const testJUnitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="pkg/api" tests="4" time="2">
+    <testcase classname="api" name="TestUpload" time="0.9"/>
+    <testcase classname="api" name="TestCompare" time="0.4">
+      <failure message="expected 2 files, got 1">compare_test.go:42</failure>
+    </testcase>
+    <testcase classname="api" name="TestTree" time="0.2">
+      <error>panic: nil map
+goroutine 1</error>
+    </testcase>
+    <testcase classname="api" name="TestLater" time="0"><skipped/></testcase>
+  </testsuite>
+  <testsuite name="pkg">
+    <testsuite name="pkg/models" time="2,000.25">
+      <testcase classname="models" name="TestBuild" time="2000.25"/>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+func TestUploadJUnit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Tests", Token: "tests-token"}
+	db.Create(&project)
+
+	build := createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{"a.go": `[1, 0]`})
+	var job models.Job
+	db.Where("build_id = ?", build.ID).First(&job)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/junit", NewJobHandler(db).UploadJUnit)
+	router.GET("/builds/:id/tests", NewBuildHandler(db).Tests)
+	router.GET("/projects/:id/trend", NewBuildHandler(db).Trend)
+
+	upload := func(report string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		url := fmt.Sprintf("/upload/junit?repo_token=tests-token&job_id=%d", job.ID)
+		req := httptest.NewRequest("POST", url, strings.NewReader(report))
+		req.Header.Set("Content-Type", "application/xml")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Uploading twice must replace the first results
+	for i := 0; i < 2; i++ {
+		if w := upload(testJUnitReport); w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	db.First(&build, build.ID)
+	if build.TestCount == nil || *build.TestCount != 5 || *build.TestsFailed != 2 || *build.TestsSkipped != 1 {
+		t.Fatalf("Expected 5 tests with 2 failed and 1 skipped on the build, got %v/%v/%v", build.TestCount, build.TestsFailed, build.TestsSkipped)
+	}
+	if *build.TestDuration != 2002.25 {
+		t.Errorf("Expected a test duration of 2002.25s from the suite times, got %v", *build.TestDuration)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/tests?limit=2", build.ID), nil)
+	router.ServeHTTP(w, req)
+
+	var tests BuildTests
+	json.Unmarshal(w.Body.Bytes(), &tests)
+	if tests.Tests != 5 || tests.Passed != 2 || tests.Failed != 1 || tests.Errors != 1 || tests.Skipped != 1 {
+		t.Errorf("Unexpected counts %+v", tests)
+	}
+	if tests.Duration != *build.TestDuration {
+		t.Errorf("Expected the duration of the build, %v, got %v", *build.TestDuration, tests.Duration)
+	}
+	if len(tests.Slowest) != 2 || tests.Slowest[0].Name != "TestBuild" || tests.Slowest[1].Name != "TestUpload" {
+		t.Errorf("Expected TestBuild and TestUpload as slowest tests, got %+v", tests.Slowest)
+	}
+	if len(tests.Failing) != 2 || tests.Failing[0].Message != "expected 2 files, got 1" || tests.Failing[1].Message != "panic: nil map" {
+		t.Errorf("Unexpected failing tests %+v", tests.Failing)
+	}
+	if tests.CoverageRate != 50 {
+		t.Errorf("Expected the build coverage next to test results, got %v", tests.CoverageRate)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/projects/"+project.ID+"/trend", nil)
+	router.ServeHTTP(w, req)
+
+	var trend CoverageTrend
+	json.Unmarshal(w.Body.Bytes(), &trend)
+	if len(trend.Series) != 1 || trend.Series[0].Points[0].Tests == nil || *trend.Series[0].Points[0].Tests != 5 {
+		t.Errorf("Expected test counts in the trend, got %+v", trend.Series)
+	}
+
+	if w := upload(`<coverage/>`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a non-JUnit report, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestParseJUnitXMLNestedSuiteDuration(t *testing.T) {
+	suites, err := parseJUnitXML(strings.NewReader(`<testsuite name="outer" time="5">
+  <testcase name="TestOuter" time="1"/>
+  <testsuite name="inner" time="4">
+    <testcase name="TestInner" time="3.5"/>
+  </testsuite>
+</testsuite>`))
+	if err != nil {
+		t.Fatalf("Failed to parse report: %v", err)
+	}
+
+	// The outer suite time includes the inner suite, which must not be counted twice
+	durations := make(map[string]float64)
+	total := 0.0
+	for _, s := range suites {
+		durations[s.Name] = s.Duration
+		total += s.Duration
+	}
+	if len(suites) != 2 || durations["outer"] != 1 || durations["inner"] != 4 || total != 5 {
+		t.Errorf("Expected outer and inner suites of 1s and 4s, got %v", durations)
+	}
+}
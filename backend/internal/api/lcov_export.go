@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// writeLCOV writes coverage as an lcov tracefile, with one record per file
+func writeLCOV(w io.Writer, export *coverageExport) error {
+	bw := bufio.NewWriter(w)
+
+	testName := ""
+	if export.Job != nil {
+		testName = export.Job.FlagName
+	}
+
+	for _, name := range sortedFileNames(export.Files) {
+		file := export.Files[name]
+		fmt.Fprintf(bw, "TN:%s\n", testName)
+		fmt.Fprintf(bw, "SF:%s\n", name)
+
+		functions := append([]FunctionCoverage(nil), export.Functions[name]...)
+		sort.SliceStable(functions, func(i, j int) bool { return functions[i].StartLine < functions[j].StartLine })
+		coveredFunctions := 0
+		for _, fn := range functions {
+			fmt.Fprintf(bw, "FN:%d,%s\n", fn.StartLine, fn.Name)
+		}
+		for _, fn := range functions {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.Hits, fn.Name)
+			if fn.Hits > 0 {
+				coveredFunctions++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(functions), coveredFunctions)
+
+		for i := 0; i+3 < len(file.Branches); i += 4 {
+			line, block, branch, hits := file.Branches[i], file.Branches[i+1], file.Branches[i+2], file.Branches[i+3]
+			// lcov marks branches of lines that never ran with "-" rather than 0
+			taken := fmt.Sprint(hits)
+			if hits == 0 && file.hitsAt(line) == 0 {
+				taken = "-"
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", line, block, branch, taken)
+		}
+		totalBranches, coveredBranches := file.branchCounts()
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", totalBranches, coveredBranches)
+
+		for i, hits := range file.Lines {
+			if hits != lineNotRelevant {
+				fmt.Fprintf(bw, "DA:%d,%d\n", i+1, hits)
+			}
+		}
+		relevant, covered := file.counts()
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", relevant, covered)
+		bw.WriteString("end_of_record\n")
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Mutant statuses of the mutation testing report schema
+const (
+	MutantKilled       = "Killed"
+	MutantSurvived     = "Survived"
+	MutantNoCoverage   = "NoCoverage"
+	MutantTimeout      = "Timeout"
+	MutantCompileError = "CompileError"
+	MutantRuntimeError = "RuntimeError"
+	MutantIgnored      = "Ignored"
+	MutantPending      = "Pending"
+)
+
+// mutationReport is a report in the mutation-testing-report-schema format used by Stryker
+type mutationReport struct {
+	SchemaVersion string `json:"schemaVersion"`
+	Files         map[string]struct {
+		Mutants []struct {
+			ID          string `json:"id"`
+			MutatorName string `json:"mutatorName"`
+			Replacement string `json:"replacement"`
+			Status      string `json:"status"`
+			Location    struct {
+				Start mutantPosition `json:"start"`
+				End   mutantPosition `json:"end"`
+			} `json:"location"`
+		} `json:"mutants"`
+	} `json:"files"`
+}
+
+// mutantPosition is a 1-based position in a source file
+type mutantPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// MutationUploadResult summarizes a mutation report upload
+type MutationUploadResult struct {
+	JobID          uint               `json:"job_id"`
+	Mutants        int                `json:"mutants"`
+	Statuses       map[string]int     `json:"statuses"`
+	MutationScore  *float64           `json:"mutation_score"`
+	UnmatchedFiles []string           `json:"unmatched_files"` // Reported files that are not part of the job
+	Files          map[string]float64 `json:"files"`           // Mutation score per file
+}
+
+// UploadMutation stores the mutants of a mutation testing report for the files of a job
+//
+//	@Summary		Upload a mutation testing report
+//	@Description	Upload a mutation testing report (mutation-testing-report-schema JSON, as written by Stryker) for a job created by a coverage upload. The report is sent as the request body or as the "file" form field, and replaces the previous mutants of the job.
+//	@Tags			coverage
+//	@Accept			json
+//	@Produce		json
+//	@Param			repo_token	query		string	true	"Project repo token"
+//	@Param			job_id		query		int		true	"Job ID returned by the coverage upload"
+//	@Success		200			{object}	MutationUploadResult
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/upload/mutation [post]
+func (h *JobHandler) UploadMutation(c *gin.Context) {
+	upload, ok := h.openReportUpload(c)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+
+	report, err := parseMutationReport(upload.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mutation report", "details": err.Error()})
+		return
+	}
+
+	var jobFiles []models.JobFile
+	if err := h.db.Select("id, name").Where("job_id = ?", upload.Job.ID).Find(&jobFiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job files"})
+		return
+	}
+
+	mutants, unmatched := matchMutants(jobFiles, report)
+
+	result := MutationUploadResult{
+		JobID:          upload.Job.ID,
+		Mutants:        len(mutants),
+		Statuses:       make(map[string]int),
+		UnmatchedFiles: unmatched,
+		Files:          make(map[string]float64),
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		fileIDs := make([]uint, len(jobFiles))
+		for i, jf := range jobFiles {
+			fileIDs[i] = jf.ID
+		}
+		// A new report replaces the previous mutants of the job
+		if err := tx.Unscoped().Where("job_file_id IN ?", fileIDs).Delete(&models.Mutant{}).Error; err != nil {
+			return err
+		}
+		if len(mutants) > 0 {
+			if err := tx.CreateInBatches(&mutants, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		byFile := make(map[uint]map[string]int)
+		for _, m := range mutants {
+			if byFile[m.JobFileID] == nil {
+				byFile[m.JobFileID] = make(map[string]int)
+			}
+			byFile[m.JobFileID][m.Status]++
+			result.Statuses[m.Status]++
+		}
+		for _, jf := range jobFiles {
+			score := mutationScore(byFile[jf.ID])
+			if score != nil {
+				result.Files[jf.Name] = *score
+			}
+			if err := tx.Model(&models.JobFile{}).Where("id = ?", jf.ID).Update("mutation_score", score).Error; err != nil {
+				return err
+			}
+		}
+
+		result.MutationScore = mutationScore(result.Statuses)
+		if err := tx.Model(&models.Job{}).Where("id = ?", upload.Job.ID).Update("mutation_score", result.MutationScore).Error; err != nil {
+			return err
+		}
+		return updateBuildMutationScore(tx, upload.Job.BuildID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store mutants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseMutationReport decodes a mutation-testing-report-schema JSON report
+func parseMutationReport(r io.Reader) (*mutationReport, error) {
+	var report mutationReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	if report.Files == nil {
+		return nil, fmt.Errorf("no files in report")
+	}
+	for name, file := range report.Files {
+		for _, m := range file.Mutants {
+			switch m.Status {
+			case MutantKilled, MutantSurvived, MutantNoCoverage, MutantTimeout,
+				MutantCompileError, MutantRuntimeError, MutantIgnored, MutantPending:
+			default:
+				return nil, fmt.Errorf("%s: unknown mutant status %q", name, m.Status)
+			}
+		}
+	}
+	return &report, nil
+}
+
+// matchMutants attaches the mutants of a report to the job files they belong
+// to, matching paths like findFileByPath. It also returns the reported files
+// that are not part of the job, or that match several of its files.
+func matchMutants(jobFiles []models.JobFile, report *mutationReport) ([]models.Mutant, []string) {
+	files := make(map[string]*fileCoverage, len(jobFiles))
+	fileIDs := make(map[string]uint, len(jobFiles))
+	for _, jf := range jobFiles {
+		files[jf.Name] = &fileCoverage{Name: jf.Name}
+		fileIDs[jf.Name] = jf.ID
+	}
+
+	names := make([]string, 0, len(report.Files))
+	for name := range report.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mutants := []models.Mutant{}
+	unmatched := []string{}
+	for _, name := range names {
+		file := findFileByPath(files, strings.TrimPrefix(name, "./"))
+		if file == nil {
+			unmatched = append(unmatched, name)
+			continue
+		}
+		for _, m := range report.Files[name].Mutants {
+			mutants = append(mutants, models.Mutant{
+				JobFileID:   fileIDs[file.Name],
+				MutantID:    m.ID,
+				MutatorName: m.MutatorName,
+				Replacement: m.Replacement,
+				Line:        m.Location.Start.Line,
+				Column:      m.Location.Start.Column,
+				EndLine:     m.Location.End.Line,
+				EndColumn:   m.Location.End.Column,
+				Status:      m.Status,
+			})
+		}
+	}
+
+	return mutants, unmatched
+}
+
+// updateBuildMutationScore recomputes the mutation score of a build from the mutants of all of its jobs
+func updateBuildMutationScore(db *gorm.DB, buildID uint) error {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	if err := db.Table("mutants").
+		Select("mutants.status, COUNT(*) AS count").
+		Joins("JOIN job_files ON job_files.id = mutants.job_file_id AND job_files.deleted_at IS NULL").
+		Joins("JOIN jobs ON jobs.id = job_files.job_id AND jobs.deleted_at IS NULL").
+		Where("jobs.build_id = ? AND mutants.deleted_at IS NULL", buildID).
+		Group("mutants.status").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	statuses := make(map[string]int, len(rows))
+	for _, r := range rows {
+		statuses[r.Status] = r.Count
+	}
+	return db.Model(&models.Build{}).Where("id = ?", buildID).Update("mutation_score", mutationScore(statuses)).Error
+}
+
+// mutationScore returns the share of detected mutants (killed or timed out)
+// among the valid ones, or nil when there are none. Mutants that did not
+// compile, crashed, were ignored or have not run yet do not count.
+func mutationScore(statuses map[string]int) *float64 {
+	detected := statuses[MutantKilled] + statuses[MutantTimeout]
+	undetected := statuses[MutantSurvived] + statuses[MutantNoCoverage]
+	if detected+undetected == 0 {
+		return nil
+	}
+	score := coverageRate(detected, detected+undetected)
+	return &score
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const testMutationReport = `{
+  "schemaVersion": "1",
+  "thresholds": {"high": 80, "low": 60},
+  "files": {
+    "src/calc.js": {
+      "language": "javascript",
+      "mutants": [
+        {"id": "1", "mutatorName": "ArithmeticOperator", "replacement": "a - b", "status": "Killed",
+         "location": {"start": {"line": 1, "column": 10}, "end": {"line": 1, "column": 15}}},
+        {"id": "2", "mutatorName": "ConditionalExpression", "replacement": "true", "status": "Survived",
+         "location": {"start": {"line": 3, "column": 7}, "end": {"line": 3, "column": 12}}},
+        {"id": "3", "mutatorName": "BlockStatement", "replacement": "{}", "status": "NoCoverage",
+         "location": {"start": {"line": 4, "column": 1}, "end": {"line": 6, "column": 2}}},
+        {"id": "4", "mutatorName": "StringLiteral", "replacement": "\"\"", "status": "CompileError",
+         "location": {"start": {"line": 5, "column": 3}, "end": {"line": 5, "column": 9}}},
+        {"id": "5", "mutatorName": "EqualityOperator", "replacement": "a !== b", "status": "Pending",
+         "location": {"start": {"line": 2, "column": 5}, "end": {"line": 2, "column": 12}}},
+        {"id": "6", "mutatorName": "BooleanLiteral", "replacement": "false", "status": "Ignored",
+         "location": {"start": {"line": 2, "column": 14}, "end": {"line": 2, "column": 18}}}
+      ]
+    },
+    "src/unknown.js": {"language": "javascript", "mutants": []}
+  }
+}`
+
+func TestUploadMutation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Mutation", Token: "mutation-token"}
+	db.Create(&project)
+
+	build := createTestBuild(t, db, project.ID, 1, "main", "c1", map[string]string{"src/calc.js": `[1, 1, 1, 0, 0, 0]`})
+	var job models.Job
+	db.Where("build_id = ?", build.ID).First(&job)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/mutation", NewJobHandler(db).UploadMutation)
+	router.GET("/files/:id", NewFileHandler(db).Get)
+
+	url := fmt.Sprintf("/upload/mutation?repo_token=mutation-token&job_id=%d", job.ID)
+	req := httptest.NewRequest("POST", url, strings.NewReader(testMutationReport))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result MutationUploadResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Mutants != 6 || len(result.UnmatchedFiles) != 1 || result.UnmatchedFiles[0] != "src/unknown.js" {
+		t.Errorf("Expected 6 mutants and src/unknown.js unmatched, got %+v", result)
+	}
+
+	// 1 killed out of 3 valid mutants; pending and ignored mutants do not count
+	db.First(&build, build.ID)
+	if build.MutationScore == nil || *build.MutationScore < 33.3 || *build.MutationScore > 33.4 {
+		t.Errorf("Expected a build mutation score of 1/3, got %v", build.MutationScore)
+	}
+
+	var file models.JobFile
+	db.Where("job_id = ?", job.ID).First(&file)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", fmt.Sprintf("/files/%d", file.ID), nil)
+	router.ServeHTTP(w, req)
+
+	json.Unmarshal(w.Body.Bytes(), &file)
+	if len(file.SurvivingMutants) != 1 || file.SurvivingMutants[0].Line != 3 || file.SurvivingMutants[0].MutatorName != "ConditionalExpression" {
+		t.Errorf("Expected the surviving mutant on line 3 in the file API, got %+v", file.SurvivingMutants)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", url, strings.NewReader(`{"files": {"src/calc.js": {"mutants": [{"status": "Zombie"}]}}}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown status, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PatchFileCoverage is the coverage of the changed lines of a single file
+type PatchFileCoverage struct {
+	Name           string  `json:"name"`
+	RelevantLines  int     `json:"relevant_lines"`
+	CoveredLines   int     `json:"covered_lines"`
+	CoverageRate   float64 `json:"coverage_rate"`
+	UncoveredLines []int   `json:"uncovered_lines"`
+}
+
+// PatchCoverage is the coverage of the lines added or modified by a change
+type PatchCoverage struct {
+	BuildID       uint                `json:"build_id"`
+	BaseCommitSHA string              `json:"base_commit_sha"`
+	RelevantLines int                 `json:"relevant_lines"`
+	CoveredLines  int                 `json:"covered_lines"`
+	CoverageRate  float64             `json:"coverage_rate"`
+	Files         []PatchFileCoverage `json:"files"`
+}
+
+// PatchInput is the change attached to a build, either as a unified diff or as changed line ranges
+type PatchInput struct {
+	BaseSHA      string              `json:"base_sha"`
+	Diff         string              `json:"diff"`
+	ChangedLines map[string][][2]int `json:"changed_lines"` // file name -> inclusive [start, end] ranges
+}
+
+// SetPatch attaches a change to a build and computes its patch coverage
+//
+//	@Summary		Attach a patch to a build
+//	@Description	Attach a unified diff (JSON field or text/x-diff body) or changed line ranges to a build and compute the coverage of the changed lines
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string		true	"Build ID"
+//	@Param			body	body		PatchInput	true	"Changed lines"
+//	@Success		200		{object}	PatchCoverage
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/patch [post]
+func (h *BuildHandler) SetPatch(c *gin.Context) {
+	id := c.Param("id")
+
+	var build models.Build
+	if err := h.db.First(&build, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	var input PatchInput
+	switch c.ContentType() {
+	case "text/x-diff", "text/x-patch", "text/plain":
+		body, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read request body"})
+			return
+		}
+		input.Diff = string(body)
+		input.BaseSHA = c.Query("base_sha")
+	default:
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if input.Diff == "" && input.ChangedLines == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either diff or changed_lines is required"})
+		return
+	}
+
+	files, err := loadBuildCoverage(h.db, build.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build files"})
+		return
+	}
+
+	var changed map[string][]int
+	if input.Diff != "" {
+		changed = parseUnifiedDiff(input.Diff)
+	} else {
+		changed, err = expandLineRanges(input.ChangedLines, files)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid changed_lines: " + err.Error()})
+			return
+		}
+	}
+
+	changedJSON, _ := json.Marshal(changed)
+	build.BaseCommitSHA = input.BaseSHA
+	build.ChangedLines = string(changedJSON)
+
+	patch := computePatchCoverage(files, changed)
+	patch.BuildID = build.ID
+	patch.BaseCommitSHA = build.BaseCommitSHA
+
+	// Patches that change no relevant line have no coverage, rather than 0%
+	build.PatchCoverageRate = nil
+	if patch.RelevantLines > 0 {
+		build.PatchCoverageRate = &patch.CoverageRate
+	}
+	if err := h.db.Save(&build).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update build"})
+		return
+	}
+
+	// Patch coverage may change the quality gate outcome. The patch is kept
+	// when this fails, and the gate is evaluated again when its status is requested.
+	if err := evaluateQualityGate(h.db, &build); err != nil {
+		log.Printf("Failed to evaluate quality gate for build %d: %v", build.ID, err)
+	}
+
+	c.JSON(http.StatusOK, &patch)
+}
+
+// GetPatch returns the patch coverage of a build
+//
+//	@Summary		Get patch coverage
+//	@Description	Get the coverage of the lines changed by the patch attached to a build
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Build ID"
+//	@Success		200	{object}	PatchCoverage
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/patch [get]
+func (h *BuildHandler) GetPatch(c *gin.Context) {
+	id := c.Param("id")
+
+	var build models.Build
+	if err := h.db.First(&build, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	if build.ChangedLines == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No patch attached to this build"})
+		return
+	}
+
+	patch, err := buildPatchCoverage(h.db, &build)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, patch)
+}
+
+// buildPatchCoverage computes the patch coverage of a build from its stored changed lines
+func buildPatchCoverage(db *gorm.DB, build *models.Build) (*PatchCoverage, error) {
+	var changed map[string][]int
+	if build.ChangedLines != "" {
+		json.Unmarshal([]byte(build.ChangedLines), &changed)
+	}
+
+	files, err := loadBuildFiles(db, build.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := computePatchCoverage(files, changed)
+	patch.BuildID = build.ID
+	patch.BaseCommitSHA = build.BaseCommitSHA
+	return &patch, nil
+}
+
+// computePatchCoverage computes the coverage of the changed lines of each file.
+// Changed lines that carry no coverage information are ignored.
+func computePatchCoverage(files map[string]*fileCoverage, changed map[string][]int) PatchCoverage {
+	patch := PatchCoverage{Files: []PatchFileCoverage{}}
+
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		file := findFileByPath(files, name)
+		if file == nil {
+			continue
+		}
+
+		fileCov := PatchFileCoverage{Name: file.Name, UncoveredLines: []int{}}
+		for _, line := range changed[name] {
+			hits := file.hitsAt(line)
+			if hits == lineNotRelevant {
+				continue
+			}
+			fileCov.RelevantLines++
+			if hits > 0 {
+				fileCov.CoveredLines++
+			} else {
+				fileCov.UncoveredLines = append(fileCov.UncoveredLines, line)
+			}
+		}
+		if fileCov.RelevantLines == 0 {
+			continue
+		}
+
+		fileCov.CoverageRate = coverageRate(fileCov.CoveredLines, fileCov.RelevantLines)
+		patch.RelevantLines += fileCov.RelevantLines
+		patch.CoveredLines += fileCov.CoveredLines
+		patch.Files = append(patch.Files, fileCov)
+	}
+
+	patch.CoverageRate = coverageRate(patch.CoveredLines, patch.RelevantLines)
+	return patch
+}
+
+// findFileByPath looks up a file by repository path, matching names like
+// matchFilePath. Paths that match several files find none, so that coverage
+// is never attributed to a file picked at random.
+func findFileByPath(files map[string]*fileCoverage, path string) *fileCoverage {
+	if file, ok := files[path]; ok {
+		return file
+	}
+	name, err := matchFilePath(sortedFileNames(files), path)
+	if err != nil || name == "" {
+		return nil
+	}
+	return files[name]
+}
+
+// ambiguousPathError is returned when a path matches the ends of several file names
+type ambiguousPathError struct {
+	path       string
+	candidates []string
+}
+
+func (e *ambiguousPathError) Error() string {
+	return fmt.Sprintf("path %s matches several files, use one of: %s", e.path, strings.Join(e.candidates, ", "))
+}
+
+// matchFilePath picks the file name a path refers to. A name equal to the
+// path wins, otherwise a name that ends with the path, or that the path ends
+// with, is accepted if it is the only one. It returns an empty name when
+// nothing matches, and an ambiguousPathError when several names do.
+func matchFilePath(names []string, path string) (string, error) {
+	var matches []string
+	for _, name := range names {
+		if name == path {
+			return name, nil
+		}
+		if strings.HasSuffix(name, "/"+path) || strings.HasSuffix(path, "/"+name) {
+			matches = append(matches, name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0], nil
+	default:
+		sort.Strings(matches)
+		return "", &ambiguousPathError{path: path, candidates: matches}
+	}
+}
+
+// parseUnifiedDiff returns the added or modified line numbers of each file in a unified diff.
+// Line numbers refer to the new version of the file.
+func parseUnifiedDiff(diff string) map[string][]int {
+	changed := make(map[string][]int)
+
+	var current string
+	newLine, oldLeft, newLeft := 0, 0, 0
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Inside a hunk every line belongs to the hunk, even if it looks like a header
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				if current != "" {
+					changed[current] = append(changed[current], newLine)
+				}
+				newLine++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				newLine++
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			current = diffPath(strings.TrimPrefix(line, "+++ "))
+		case strings.HasPrefix(line, "@@"):
+			newLine, oldLeft, newLeft = parseHunkHeader(line)
+		}
+	}
+
+	return changed
+}
+
+// diffPath extracts the file path from a "+++" header, dropping the "b/" prefix and timestamps
+func diffPath(header string) string {
+	if i := strings.IndexByte(header, '\t'); i >= 0 {
+		header = header[:i]
+	}
+	if header == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(header, "b/")
+}
+
+// parseHunkHeader parses a "@@ -a,b +c,d @@" hunk header into the first
+// new-file line number and the old and new line counts
+func parseHunkHeader(header string) (start, oldCount, newCount int) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0
+	}
+	_, oldCount = parseHunkRange(strings.TrimPrefix(fields[1], "-"))
+	start, newCount = parseHunkRange(strings.TrimPrefix(fields[2], "+"))
+	return start, oldCount, newCount
+}
+
+// parseHunkRange parses "start,count" where the count defaults to 1
+func parseHunkRange(r string) (start, count int) {
+	count = 1
+	if i := strings.IndexByte(r, ','); i >= 0 {
+		count, _ = strconv.Atoi(r[i+1:])
+		r = r[:i]
+	}
+	start, _ = strconv.Atoi(r)
+	return start, count
+}
+
+// expandLineRanges turns inclusive [start, end] line ranges into line numbers.
+// Ranges are clamped to the lines of the covered file, since lines past its end
+// carry no coverage, and ranges of files without coverage are dropped.
+func expandLineRanges(ranges map[string][][2]int, files map[string]*fileCoverage) (map[string][]int, error) {
+	changed := make(map[string][]int, len(ranges))
+	for name, fileRanges := range ranges {
+		for _, r := range fileRanges {
+			if r[0] < 1 || r[0] > r[1] {
+				return nil, fmt.Errorf("invalid line range [%d, %d] for %s", r[0], r[1], name)
+			}
+		}
+
+		file := findFileByPath(files, name)
+		if file == nil {
+			continue
+		}
+		for _, r := range fileRanges {
+			for line := r[0]; line <= min(r[1], len(file.Lines)); line++ {
+				changed[name] = append(changed[name], line)
+			}
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const testDiff = `diff --git a/src/main.go b/src/main.go
+index 1111111..2222222 100644
+--- a/src/main.go
++++ b/src/main.go
+@@ -1,4 +1,5 @@
+ package main
+-func old() {}
++func a() {}
++-- looks like a header
+
+ func main() {}
+@@ -10,2 +11,3 @@ func main() {}
+ x
++y
+ z
+diff --git a/gone.go b/gone.go
+deleted file mode 100644
+--- a/gone.go
++++ /dev/null
+@@ -1,1 +0,0 @@
+-package gone
+`
+
+func TestParseUnifiedDiff(t *testing.T) {
+	changed := parseUnifiedDiff(testDiff)
+
+	expected := map[string][]int{"src/main.go": {2, 3, 12}}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected %v, got %v", expected, changed)
+	}
+}
+
+func TestSetPatchComputesPatchCoverage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Patch", Token: "patch-token"}
+	db.Create(&project)
+	build := createTestBuild(t, db, project.ID, 1, "feature", "abc", map[string]string{
+		"src/main.go": `[null, 1, 0, null, null, null, null, null, null, null, null, 0]`,
+	})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/builds/:id/patch", NewBuildHandler(db).SetPatch)
+
+	body, _ := json.Marshal(PatchInput{BaseSHA: "base123", Diff: testDiff})
+	req := httptest.NewRequest("POST", fmt.Sprintf("/builds/%d/patch", build.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var patch PatchCoverage
+	json.Unmarshal(w.Body.Bytes(), &patch)
+	if patch.RelevantLines != 3 || patch.CoveredLines != 1 {
+		t.Errorf("Expected 1 of 3 changed lines covered, got %d of %d", patch.CoveredLines, patch.RelevantLines)
+	}
+	if len(patch.Files) != 1 || !reflect.DeepEqual(patch.Files[0].UncoveredLines, []int{3, 12}) {
+		t.Errorf("Expected lines 3 and 12 uncovered, got %+v", patch.Files)
+	}
+
+	var stored models.Build
+	db.First(&stored, build.ID)
+	if stored.BaseCommitSHA != "base123" || stored.PatchCoverageRate == nil {
+		t.Errorf("Expected patch to be stored on the build, got %+v", stored)
+	}
+
+	// A patch without relevant changed lines has no coverage rate
+	w = httptest.NewRecorder()
+	body, _ = json.Marshal(PatchInput{BaseSHA: "base123", ChangedLines: map[string][][2]int{"src/main.go": {{4, 6}}}})
+	req = httptest.NewRequest("POST", fmt.Sprintf("/builds/%d/patch", build.ID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	stored = models.Build{}
+	db.First(&stored, build.ID)
+	if stored.PatchCoverageRate != nil {
+		t.Errorf("Expected no patch coverage rate, got %v", *stored.PatchCoverageRate)
+	}
+}
+
+func TestFindFileByPath(t *testing.T) {
+	files := map[string]*fileCoverage{
+		"a/util.go":   {Name: "a/util.go", Lines: []int{1}},
+		"b/util.go":   {Name: "b/util.go", Lines: []int{0}},
+		"src/main.go": {Name: "src/main.go", Lines: []int{1}},
+	}
+
+	tests := []struct {
+		path, expected string
+	}{
+		{"a/util.go", "a/util.go"},
+		{"main.go", "src/main.go"},
+		{"repo/src/main.go", "src/main.go"},
+		{"util.go", ""},
+		{"missing.go", ""},
+	}
+	for _, tt := range tests {
+		// Repeat lookups, which must not depend on map order
+		for i := 0; i < 10; i++ {
+			name := ""
+			if file := findFileByPath(files, tt.path); file != nil {
+				name = file.Name
+			}
+			if name != tt.expected {
+				t.Errorf("findFileByPath(%q) = %q, expected %q", tt.path, name, tt.expected)
+				break
+			}
+		}
+	}
+
+	changed := map[string][]int{"util.go": {1}, "src/main.go": {1}}
+	if patch := computePatchCoverage(files, changed); len(patch.Files) != 1 || patch.Files[0].Name != "src/main.go" {
+		t.Errorf("Expected the ambiguous path to be skipped, got %+v", patch.Files)
+	}
+}
+
+func TestExpandLineRanges(t *testing.T) {
+	files := map[string]*fileCoverage{
+		"src/main.go": {Name: "src/main.go", Lines: parseLineHits(`[1, 0, 1, 0]`)},
+	}
+
+	changed, err := expandLineRanges(map[string][][2]int{
+		"src/main.go": {{2, 3}, {4, 2000000000}},
+		"missing.go":  {{1, 5}},
+	}, files)
+	if err != nil {
+		t.Fatalf("Failed to expand ranges: %v", err)
+	}
+	expected := map[string][]int{"src/main.go": {2, 3, 4}}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected %v, got %v", expected, changed)
+	}
+
+	for _, r := range [][2]int{{0, 3}, {5, 4}, {-3, -1}} {
+		if _, err := expandLineRanges(map[string][][2]int{"src/main.go": {r}}, files); err == nil {
+			t.Errorf("Expected an error for range %v", r)
+		}
+	}
+}
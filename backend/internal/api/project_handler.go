@@ -214,7 +214,7 @@ func (h *ProjectHandler) Create(c *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			id		path		string														true	"Project ID"
-//	@Param			body	body		object{name=string,current_branch=string,base_url=string}	true	"Project data"
+//	@Param			body	body		object{name=string,current_branch=string,base_url=string,exclude_flaky_lines=bool,badge_low_threshold=number,badge_high_threshold=number,private_badges=bool}	true	"Project data"
 //	@Success		200		{object}	models.Project
 //	@Failure		400		{object}	map[string]string
 //	@Failure		401		{object}	map[string]string
@@ -247,9 +247,13 @@ func (h *ProjectHandler) Update(c *gin.Context) {
 	}
 
 	var input struct {
-		Name          string `json:"name"`
-		CurrentBranch string `json:"current_branch"`
-		BaseURL       string `json:"base_url"`
+		Name               string   `json:"name"`
+		CurrentBranch      string   `json:"current_branch"`
+		BaseURL            string   `json:"base_url"`
+		ExcludeFlakyLines  *bool    `json:"exclude_flaky_lines"`
+		BadgeLowThreshold  *float64 `json:"badge_low_threshold"`
+		BadgeHighThreshold *float64 `json:"badge_high_threshold"`
+		PrivateBadges      *bool    `json:"private_badges"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -266,6 +270,22 @@ func (h *ProjectHandler) Update(c *gin.Context) {
 	if input.BaseURL != "" {
 		project.BaseURL = input.BaseURL
 	}
+	if input.ExcludeFlakyLines != nil {
+		project.ExcludeFlakyLines = *input.ExcludeFlakyLines
+	}
+	if input.BadgeLowThreshold != nil {
+		project.BadgeLowThreshold = input.BadgeLowThreshold
+	}
+	if input.BadgeHighThreshold != nil {
+		project.BadgeHighThreshold = input.BadgeHighThreshold
+	}
+	if input.PrivateBadges != nil {
+		project.PrivateBadges = *input.PrivateBadges
+	}
+	if low, high := badgeThresholds(&project); low < 0 || high > 100 || low > high {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Badge thresholds must satisfy 0 <= low <= high <= 100"})
+		return
+	}
 
 	if err := h.db.Save(&project).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/middleware"
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Quality gate statuses reported for builds
+const (
+	GateStatusPassed = "passed"
+	GateStatusFailed = "failed"
+	GateStatusNone   = "none"
+)
+
+// GateResult is the outcome of a project's quality gate for a build
+type GateResult struct {
+	BuildID  uint     `json:"build_id"`
+	Status   string   `json:"status"`    // passed, failed or none
+	ExitCode int      `json:"exit_code"` // 0 unless the gate failed
+	Reasons  []string `json:"reasons"`
+}
+
+// GetQualityGate returns the quality gate of a project
+//
+//	@Summary		Get project quality gate
+//	@Description	Get the coverage rules evaluated against every build of a project
+//	@Tags			projects
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		string	true	"Project ID"
+//	@Success		200	{object}	models.QualityGate
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/quality-gate [get]
+func (h *ProjectHandler) GetQualityGate(c *gin.Context) {
+	projectID := c.Param("id")
+
+	var gate models.QualityGate
+	if err := h.db.Preload("PathRules").Where("project_id = ?", projectID).First(&gate).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Quality gate not configured"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quality gate"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gate)
+}
+
+// UpdateQualityGate creates or replaces the quality gate of a project
+//
+//	@Summary		Update project quality gate
+//	@Description	Create or replace the coverage rules evaluated against every build of a project
+//	@Tags			projects
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string																						true	"Project ID"
+//	@Param			body	body		object{min_coverage=number,max_drop=number,min_patch_coverage=number,base_branch=string}	true	"Gate rules"
+//	@Success		200		{object}	models.QualityGate
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/quality-gate [put]
+func (h *ProjectHandler) UpdateQualityGate(c *gin.Context) {
+	projectID := c.Param("id")
+	user, exists := middleware.GetCurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	// Verify user owns the project
+	var project models.Project
+	query := h.db
+	if !user.Admin {
+		query = query.Where("user_id = ?", user.ID)
+	}
+
+	if err := query.Where("id = ?", projectID).First(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
+		}
+		return
+	}
+
+	var input struct {
+		MinCoverage      *float64 `json:"min_coverage"`
+		MaxDrop          *float64 `json:"max_drop"`
+		MinPatchCoverage *float64 `json:"min_patch_coverage"`
+		BaseBranch       string   `json:"base_branch"`
+		PathRules        []struct {
+			Path        string  `json:"path" binding:"required"`
+			MinCoverage float64 `json:"min_coverage"`
+		} `json:"path_rules"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var gate models.QualityGate
+	if err := h.db.Where("project_id = ?", project.ID).First(&gate).Error; err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quality gate"})
+		return
+	}
+
+	gate.ProjectID = project.ID
+	gate.MinCoverage = input.MinCoverage
+	gate.MaxDrop = input.MaxDrop
+	gate.MinPatchCoverage = input.MinPatchCoverage
+	gate.BaseBranch = input.BaseBranch
+	gate.PathRules = nil
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&gate).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("quality_gate_id = ?", gate.ID).Delete(&models.QualityGatePathRule{}).Error; err != nil {
+			return err
+		}
+		for _, r := range input.PathRules {
+			rule := models.QualityGatePathRule{QualityGateID: gate.ID, Path: r.Path, MinCoverage: r.MinCoverage}
+			if err := tx.Create(&rule).Error; err != nil {
+				return err
+			}
+			gate.PathRules = append(gate.PathRules, rule)
+		}
+		// Results of the previous rules are stale; builds are evaluated again on demand
+		return tx.Model(&models.Build{}).Where("project_id = ?", project.ID).
+			Updates(map[string]interface{}{"gate_status": "", "gate_reasons": ""}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quality gate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gate)
+}
+
+// GetGateStatus returns the quality gate result of a build
+//
+//	@Summary		Get build quality gate status
+//	@Description	Get the quality gate result of a build. With strict=true a failed gate answers 422 so that "curl --fail" exits non-zero.
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Build ID"
+//	@Param			strict	query		bool	false	"Answer 422 when the gate failed"
+//	@Success		200		{object}	GateResult
+//	@Failure		404		{object}	map[string]string
+//	@Failure		422		{object}	GateResult
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/gate [get]
+func (h *BuildHandler) GetGateStatus(c *gin.Context) {
+	id := c.Param("id")
+
+	var build models.Build
+	if err := h.db.First(&build, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	// Builds uploaded before the gate was configured or last updated are evaluated on demand
+	if build.GateStatus == "" {
+		if err := evaluateQualityGate(h.db, &build); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate quality gate"})
+			return
+		}
+	}
+
+	result := newGateResult(&build)
+	if result.Status == GateStatusFailed && c.Query("strict") == "true" {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// newGateResult builds the API representation of the gate result stored on a build
+func newGateResult(build *models.Build) GateResult {
+	result := GateResult{BuildID: build.ID, Status: build.GateStatus, Reasons: []string{}}
+	if result.Status == "" {
+		result.Status = GateStatusNone
+	}
+	if build.GateReasons != "" {
+		json.Unmarshal([]byte(build.GateReasons), &result.Reasons)
+	}
+	if result.Status == GateStatusFailed {
+		result.ExitCode = 1
+	}
+	return result
+}
+
+// evaluateQualityGate checks a build against its project's quality gate and
+// stores the status and failure reasons on the build
+func evaluateQualityGate(db *gorm.DB, build *models.Build) error {
+	var gate models.QualityGate
+	err := db.Preload("PathRules").Where("project_id = ?", build.ProjectID).First(&gate).Error
+	if err == gorm.ErrRecordNotFound {
+		build.GateStatus = ""
+		build.GateReasons = ""
+		return db.Model(build).Select("gate_status", "gate_reasons").Updates(build).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	reasons, err := checkQualityGate(db, &gate, build)
+	if err != nil {
+		return err
+	}
+
+	build.GateStatus = GateStatusPassed
+	build.GateReasons = ""
+	if len(reasons) > 0 {
+		reasonsJSON, _ := json.Marshal(reasons)
+		build.GateStatus = GateStatusFailed
+		build.GateReasons = string(reasonsJSON)
+	}
+	return db.Model(build).Select("gate_status", "gate_reasons").Updates(build).Error
+}
+
+// checkQualityGate returns the reasons a build does not satisfy a gate
+func checkQualityGate(db *gorm.DB, gate *models.QualityGate, build *models.Build) ([]string, error) {
+	var reasons []string
+
+	var project models.Project
+	if err := db.Select("current_branch", "exclude_flaky_lines").Where("id = ?", build.ProjectID).First(&project).Error; err != nil {
+		return nil, err
+	}
+
+	// Files are only loaded when a rule needs them; flaky lines require
+	// recomputing every rate from the files
+	var files map[string]*fileCoverage
+	var flaky map[string]map[int]bool
+	headRate := build.CoverageRate
+	if project.ExcludeFlakyLines {
+		var err error
+		if flaky, err = loadFlakyLines(db, build.ProjectID); err != nil {
+			return nil, err
+		}
+		if files, err = loadBuildCoverage(db, build.ID); err != nil {
+			return nil, err
+		}
+		excludeFlakyLines(files, flaky)
+		headRate = totalCoverageRate(files)
+	}
+
+	if gate.MinCoverage != nil && headRate < *gate.MinCoverage {
+		reasons = append(reasons, fmt.Sprintf("Total coverage %.2f%% is below the minimum of %.2f%%", headRate, *gate.MinCoverage))
+	}
+
+	if gate.MaxDrop != nil {
+		baseBranch := gate.BaseBranch
+		if baseBranch == "" {
+			baseBranch = project.CurrentBranch
+		}
+		base, err := findGateBaseBuild(db, build, baseBranch)
+		if err != nil {
+			return nil, err
+		}
+		if base != nil {
+			baseRate := base.CoverageRate
+			if project.ExcludeFlakyLines {
+				baseFiles, err := loadBuildCoverage(db, base.ID)
+				if err != nil {
+					return nil, err
+				}
+				excludeFlakyLines(baseFiles, flaky)
+				baseRate = totalCoverageRate(baseFiles)
+			}
+			drop := baseRate - headRate
+			if drop > *gate.MaxDrop {
+				reasons = append(reasons, fmt.Sprintf("Coverage dropped by %.2f%% versus build #%d on %s, more than the allowed %.2f%%", drop, base.BuildNum, base.Branch, *gate.MaxDrop))
+			}
+		}
+	}
+
+	if gate.MinPatchCoverage == nil && len(gate.PathRules) == 0 {
+		return reasons, nil
+	}
+
+	if files == nil {
+		var err error
+		if files, err = loadBuildCoverage(db, build.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if gate.MinPatchCoverage != nil && build.ChangedLines != "" {
+		var changed map[string][]int
+		json.Unmarshal([]byte(build.ChangedLines), &changed)
+		patch := computePatchCoverage(files, changed)
+		if patch.RelevantLines > 0 && patch.CoverageRate < *gate.MinPatchCoverage {
+			reasons = append(reasons, fmt.Sprintf("Patch coverage %.2f%% is below the minimum of %.2f%%", patch.CoverageRate, *gate.MinPatchCoverage))
+		}
+	}
+
+	for _, rule := range gate.PathRules {
+		total, covered := 0, 0
+		for name, file := range files {
+			if !pathRuleMatches(name, rule.Path) {
+				continue
+			}
+			relevant, hit := file.counts()
+			total += relevant
+			covered += hit
+		}
+		if total == 0 {
+			continue
+		}
+		if rate := coverageRate(covered, total); rate < rule.MinCoverage {
+			reasons = append(reasons, fmt.Sprintf("Coverage of %s is %.2f%%, below the minimum of %.2f%%", rule.Path, rate, rule.MinCoverage))
+		}
+	}
+
+	return reasons, nil
+}
+
+// pathRuleMatches reports whether a path rule of a quality gate applies to a
+// file. Rules name a file or a directory, so a rule for src covers src/x.go
+// but not srcfoo/x.go.
+func pathRuleMatches(name, rulePath string) bool {
+	return name == rulePath || strings.HasPrefix(name, strings.TrimSuffix(rulePath, "/")+"/")
+}
+
+// findGateBaseBuild returns the latest earlier build on the base branch,
+// or nil when there is none
+func findGateBaseBuild(db *gorm.DB, build *models.Build, baseBranch string) (*models.Build, error) {
+	var base models.Build
+	err := db.Where("project_id = ? AND branch = ? AND build_num < ?", build.ProjectID, baseBranch, build.BuildNum).
+		Order("build_num DESC").
+		First(&base).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &base, nil
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestQualityGateEvaluation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Gated", Token: "gated-token", CurrentBranch: "main"}
+	db.Create(&project)
+
+	minCoverage := 60.0
+	maxDrop := 10.0
+	gate := models.QualityGate{
+		ProjectID:   project.ID,
+		MinCoverage: &minCoverage,
+		MaxDrop:     &maxDrop,
+		PathRules:   []models.QualityGatePathRule{{Path: "pkg/", MinCoverage: 90}},
+	}
+	if err := db.Create(&gate).Error; err != nil {
+		t.Fatalf("Failed to create quality gate: %v", err)
+	}
+
+	createTestBuild(t, db, project.ID, 1, "main", "base", map[string]string{
+		"pkg/a.go": `[1, 1, 1, 1]`,
+		"main.go":  `[1, 1, 1, 1]`,
+	})
+	head := createTestBuild(t, db, project.ID, 2, "feature", "head", map[string]string{
+		"pkg/a.go": `[1, 1, 0, 0]`,
+		"main.go":  `[1, 1, 1, 0]`,
+	})
+
+	if err := evaluateQualityGate(db, &head); err != nil {
+		t.Fatalf("Failed to evaluate quality gate: %v", err)
+	}
+	if head.GateStatus != GateStatusFailed {
+		t.Fatalf("Expected gate to fail, got %q", head.GateStatus)
+	}
+
+	result := newGateResult(&head)
+	// Total 62.5% passes the minimum, but drops 37.5 points and pkg/ is at 50%
+	if len(result.Reasons) != 2 || result.ExitCode != 1 {
+		t.Errorf("Expected 2 failure reasons and exit code 1, got %+v", result)
+	}
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/builds/:id/gate", NewBuildHandler(db).GetGateStatus)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/gate?strict=true", head.ID), nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	var polled GateResult
+	json.Unmarshal(w.Body.Bytes(), &polled)
+	if polled.Status != GateStatusFailed {
+		t.Errorf("Expected polled status failed, got %q", polled.Status)
+	}
+}
+
+func TestQualityGateNotConfigured(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Ungated", Token: "ungated-token"}
+	db.Create(&project)
+	build := createTestBuild(t, db, project.ID, 1, "main", "sha", map[string]string{"main.go": `[0]`})
+
+	if err := evaluateQualityGate(db, &build); err != nil {
+		t.Fatalf("Failed to evaluate quality gate: %v", err)
+	}
+
+	if result := newGateResult(&build); result.Status != GateStatusNone || result.ExitCode != 0 {
+		t.Errorf("Expected status none with exit code 0, got %+v", result)
+	}
+}
+
+func TestUpdateQualityGateReevaluatesBuilds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	user := models.User{Email: "gate-owner@example.com"}
+	db.Create(&user)
+	project := models.Project{Name: "Regated", Token: "regated-token", UserID: user.ID}
+	db.Create(&project)
+
+	minCoverage := 90.0
+	db.Create(&models.QualityGate{ProjectID: project.ID, MinCoverage: &minCoverage})
+	build := createTestBuild(t, db, project.ID, 1, "main", "sha", map[string]string{"main.go": `[1, 1, 0]`})
+	if err := evaluateQualityGate(db, &build); err != nil {
+		t.Fatalf("Failed to evaluate quality gate: %v", err)
+	}
+	if build.GateStatus != GateStatusFailed {
+		t.Fatalf("Expected gate to fail, got %q", build.GateStatus)
+	}
+
+	router := gin.New()
+	router.PUT("/projects/:id/quality-gate", func(c *gin.Context) {
+		c.Set("user", &user)
+		NewProjectHandler(db).UpdateQualityGate(c)
+	})
+	router.GET("/builds/:id/gate", NewBuildHandler(db).GetGateStatus)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/projects/"+project.ID+"/quality-gate", bytes.NewBufferString(`{"min_coverage": 50}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/gate?strict=true", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d after relaxing the gate, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result GateResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Status != GateStatusPassed {
+		t.Errorf("Expected the build to be evaluated against the new rules, got %+v", result)
+	}
+}
+
+func TestPathRuleMatches(t *testing.T) {
+	tests := []struct {
+		name, rulePath string
+		expected       bool
+	}{
+		{"src/x.go", "src", true},
+		{"src/x.go", "src/", true},
+		{"src/pkg/x.go", "src", true},
+		{"src/x.go", "src/x.go", true},
+		{"srcfoo/x.go", "src", false},
+		{"src_old/x.go", "src/", false},
+		{"src.go", "src", false},
+	}
+	for _, tt := range tests {
+		if got := pathRuleMatches(tt.name, tt.rulePath); got != tt.expected {
+			t.Errorf("pathRuleMatches(%q, %q) = %v, expected %v", tt.name, tt.rulePath, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// reportUpload is a report attached by CI to a job created by a coverage upload
+type reportUpload struct {
+	Job  models.Job
+	Body io.Reader
+	file io.Closer
+}
+
+// Close releases the uploaded file, if any
+func (r *reportUpload) Close() {
+	if r.file != nil {
+		r.file.Close()
+	}
+}
+
+// reportParam reads an upload parameter from the query string or, for
+// multipart uploads, from the form
+func reportParam(c *gin.Context, name string) string {
+	value := c.Query(name)
+	if c.ContentType() == "multipart/form-data" {
+		value = c.DefaultPostForm(name, value)
+	}
+	return value
+}
+
+// openReportUpload authenticates a report upload with the project repo token,
+// finds the target job and opens the report, sent either as the request body
+// or as the "file" field of a multipart form. It writes the error response
+// and returns false on failure.
+func (h *JobHandler) openReportUpload(c *gin.Context) (*reportUpload, bool) {
+	repoToken := reportParam(c, "repo_token")
+	if repoToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid repo token"})
+		return nil, false
+	}
+
+	var project models.Project
+	if err := h.db.Where("token = ?", repoToken).First(&project).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid repo token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, false
+	}
+
+	upload := &reportUpload{Body: c.Request.Body}
+	if err := h.db.Joins("JOIN builds ON builds.id = jobs.build_id AND builds.deleted_at IS NULL").
+		Where("jobs.id = ? AND builds.project_id = ?", reportParam(c, "job_id"), project.ID).
+		First(&upload.Job).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		}
+		return nil, false
+	}
+
+	if c.ContentType() == "multipart/form-data" {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+			return nil, false
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read report"})
+			return nil, false
+		}
+		upload.Body = f
+		upload.file = f
+	}
+
+	return upload, true
+}
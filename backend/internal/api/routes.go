@@ -61,12 +61,26 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, oidcProvider *auth.OIDCProvide
 			protected.PUT("/projects/:id", projectHandler.Update)
 			protected.DELETE("/projects/:id", projectHandler.Delete)
 
+			// Project quality gate
+			protected.GET("/projects/:id/quality-gate", projectHandler.GetQualityGate)
+			protected.PUT("/projects/:id/quality-gate", projectHandler.UpdateQualityGate)
+
+			// Flaky coverage
+			protected.GET("/projects/:id/flaky", projectHandler.GetFlaky)
+			protected.POST("/projects/:id/flaky/analyze", projectHandler.AnalyzeFlaky)
+			protected.GET("/projects/:id/hotspots", projectHandler.GetHotspots)
+
 			// Project tokens
 			protected.GET("/projects/:id/tokens", server.GetProjectTokens)
 			protected.POST("/projects/:id/tokens", server.CreateProjectToken)
 			protected.DELETE("/projects/:id/tokens/:tokenId", server.DeleteProjectToken)
 			protected.POST("/projects/:id/refresh-token", server.RefreshProjectToken)
 
+			// Badge tokens
+			protected.GET("/projects/:id/badge-tokens", server.GetBadgeTokens)
+			protected.POST("/projects/:id/badge-tokens", server.CreateBadgeToken)
+			protected.DELETE("/projects/:id/badge-tokens/:tokenId", server.DeleteBadgeToken)
+
 			// Project shares
 			protected.GET("/projects/:id/shares", projectHandler.GetShares)
 			protected.POST("/projects/:id/shares", projectHandler.CreateShare)
@@ -83,16 +97,35 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, oidcProvider *auth.OIDCProvide
 			buildHandler := NewBuildHandler(db)
 			protected.GET("/projects/:id/builds", buildHandler.List)
 			protected.GET("/builds/:id", buildHandler.Get)
+			protected.GET("/projects/:id/compare", buildHandler.Compare)
+			protected.GET("/builds/:id/patch", buildHandler.GetPatch)
+			protected.POST("/builds/:id/patch", buildHandler.SetPatch)
+			protected.GET("/builds/:id/gate", buildHandler.GetGateStatus)
+			protected.GET("/projects/:id/trend", buildHandler.Trend)
+			protected.GET("/builds/:id/tree", buildHandler.Tree)
+			protected.GET("/builds/:id/complexity", buildHandler.Complexity)
+			protected.GET("/builds/:id/functions", buildHandler.Functions)
+			protected.GET("/builds/:id/tests", buildHandler.Tests)
+			protected.GET("/builds/:id/contexts", buildHandler.Contexts)
 
 			// Jobs
 			jobHandler := NewJobHandler(db)
 			protected.GET("/jobs/:id", jobHandler.Get)
 			protected.GET("/builds/:id/jobs", jobHandler.ListByBuild)
+			protected.GET("/jobs/:id/tree", jobHandler.Tree)
 
 			// Files
 			fileHandler := NewFileHandler(db)
 			protected.GET("/jobs/:id/files", fileHandler.List)
 			protected.GET("/files/:id", fileHandler.Get)
+			protected.GET("/projects/:id/files/history", fileHandler.History)
+
+			// Coverage exports
+			exportHandler := NewExportHandler(db)
+			protected.GET("/builds/:id/export/:format", exportHandler.ExportBuild)
+			protected.GET("/jobs/:id/export/:format", exportHandler.ExportJob)
+			protected.GET("/projects/:id/export/:format", exportHandler.ExportLatest)
+			protected.GET("/projects/:id/history/export", exportHandler.ExportHistory)
 		}
 
 		// Admin routes
@@ -107,17 +140,26 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, oidcProvider *auth.OIDCProvide
 
 			projectHandler := NewProjectHandler(db)
 			admin.GET("/projects", projectHandler.ListAll)
+
+			exportHandler := NewExportHandler(db)
+			admin.GET("/history/export", exportHandler.ExportHistory)
 		}
 	}
 
 	// Coveralls-compatible upload endpoint
 	router.POST("/upload/v2", NewJobHandler(db).Upload)
+	router.POST("/upload/complexity", NewJobHandler(db).UploadComplexity)
+	router.POST("/upload/junit", NewJobHandler(db).UploadJUnit)
+	router.POST("/upload/mutation", NewJobHandler(db).UploadMutation)
+	router.POST("/upload/gocoverdir", NewJobHandler(db).UploadGoCoverDir)
 
 	// Webhook endpoint
 	router.POST("/webhook", NewWebhookHandler(db).HandleWebhook)
 
-	// Badge endpoint
+	// Badge endpoints
 	router.GET("/projects/:id/badge.svg", NewBadgeHandler(db).GetBadge)
+	router.GET("/projects/:id/badge.json", NewBadgeHandler(db).GetBadgeJSON)
+	router.GET("/projects/:id/sparkline.svg", NewBadgeHandler(db).GetSparkline)
 
 	// Serve frontend static files in production
 	router.Static("/assets", "./frontend/dist/assets")
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// SARIF result levels
+const (
+	sarifLevelError   = "error"
+	sarifLevelWarning = "warning"
+	sarifLevelNote    = "note"
+)
+
+// Indexes of the SARIF rules
+const (
+	sarifRuleUncoveredLine = iota
+	sarifRuleUncoveredChangedLine
+)
+
+// sarifSourceRoot is the base of result URIs, which are relative to the repository root
+const sarifSourceRoot = "%SRCROOT%"
+
+// sarifLog is the root object of a SARIF 2.1.0 log
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool                     sarifTool                    `json:"tool"`
+	OriginalURIBaseIDs       map[string]sarifLocationURI  `json:"originalUriBaseIds,omitempty"`
+	VersionControlProvenance []sarifVersionControlDetails `json:"versionControlProvenance,omitempty"`
+	Results                  []sarifResult                `json:"results"`
+	Properties               map[string]interface{}       `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	Help                 sarifMessage       `json:"help"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifVersionControlDetails struct {
+	RepositoryURI string `json:"repositoryUri"`
+	RevisionID    string `json:"revisionId,omitempty"`
+	Branch        string `json:"branch,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifLocationURI `json:"artifactLocation"`
+	Region           sarifRegion      `json:"region"`
+}
+
+type sarifLocationURI struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarifRules are the rules of the results, by index
+var sarifRules = []sarifRule{
+	{
+		ID:                   "uncovered-line",
+		Name:                 "UncoveredLine",
+		ShortDescription:     sarifMessage{Text: "Line not covered by tests"},
+		Help:                 sarifMessage{Text: "Add tests that run this line, or exclude it from coverage if it cannot run."},
+		DefaultConfiguration: sarifConfiguration{Level: sarifLevelWarning},
+	},
+	{
+		ID:                   "uncovered-changed-line",
+		Name:                 "UncoveredChangedLine",
+		ShortDescription:     sarifMessage{Text: "Changed line not covered by tests"},
+		Help:                 sarifMessage{Text: "Add tests that run this line, or exclude it from coverage if it cannot run."},
+		DefaultConfiguration: sarifConfiguration{Level: sarifLevelWarning},
+	},
+}
+
+// writeSARIF writes the uncovered lines as SARIF 2.1.0 results for code scanning tools.
+// When a patch is attached to the build, only the uncovered changed lines are reported.
+func writeSARIF(w io.Writer, export *coverageExport) error {
+	gate, err := loadSARIFGate(export.db, export.Project.ID)
+	if err != nil {
+		return err
+	}
+
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "LibreCov", InformationURI: "https://github.com/Frantche/Librecov", Rules: sarifRules}},
+		Results: []sarifResult{},
+		Properties: map[string]interface{}{
+			"buildId":  export.Build.ID,
+			"buildNum": export.Build.BuildNum,
+		},
+	}
+	if root := strings.TrimSuffix(export.SourceRoot, "/"); path.IsAbs(root) {
+		run.OriginalURIBaseIDs = map[string]sarifLocationURI{
+			sarifSourceRoot: {URI: (&url.URL{Scheme: "file", Path: root + "/"}).String()},
+		}
+	}
+	if export.Project.BaseURL != "" {
+		run.VersionControlProvenance = []sarifVersionControlDetails{{
+			RepositoryURI: export.Project.BaseURL,
+			RevisionID:    export.Build.CommitSHA,
+			Branch:        export.Build.Branch,
+		}}
+	}
+
+	if export.Build.ChangedLines != "" {
+		var changed map[string][]int
+		json.Unmarshal([]byte(export.Build.ChangedLines), &changed)
+		patch := computePatchCoverage(export.Files, changed)
+
+		// Changed lines are held to the minimum patch coverage of the gate
+		level := sarifLevelNote
+		if gate != nil && gate.MinPatchCoverage != nil {
+			level = sarifLevelWarning
+			if patch.CoverageRate < *gate.MinPatchCoverage {
+				level = sarifLevelError
+			}
+		}
+		run.Properties["baseCommitSha"] = export.Build.BaseCommitSHA
+		for _, file := range patch.Files {
+			for _, line := range file.UncoveredLines {
+				location := sarifArtifactLocation(file.Name, export.SourceRoot)
+				run.Results = append(run.Results, newSARIFResult(sarifRuleUncoveredChangedLine, level, location, line, fmt.Sprintf("Changed line %d is not covered by tests", line)))
+			}
+		}
+	} else {
+		levels := sarifPathLevels(gate, export.Files)
+		for _, name := range sortedFileNames(export.Files) {
+			level := levels(name)
+			location := sarifArtifactLocation(name, export.SourceRoot)
+			for i, hits := range export.Files[name].Lines {
+				if hits == 0 {
+					run.Results = append(run.Results, newSARIFResult(sarifRuleUncoveredLine, level, location, i+1, fmt.Sprintf("Line %d is not covered by tests", i+1)))
+				}
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Schema: "https://json.schemastore.org/sarif-2.1.0.json", Version: "2.1.0", Runs: []sarifRun{run}})
+}
+
+// newSARIFResult reports an uncovered line of a file
+func newSARIFResult(ruleIndex int, level string, location sarifLocationURI, line int, message string) sarifResult {
+	return sarifResult{
+		RuleID:    sarifRules[ruleIndex].ID,
+		RuleIndex: ruleIndex,
+		Level:     level,
+		Message:   sarifMessage{Text: message},
+		Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: location,
+			Region:           sarifRegion{StartLine: line},
+		}}},
+	}
+}
+
+// sarifArtifactLocation locates a file for code scanning tools, which resolve
+// URIs relative to the repository root. Names under the source root of the
+// export are made relative to it. Other absolute names cannot be mapped to
+// the repository and are kept as absolute file URIs.
+func sarifArtifactLocation(name, sourceRoot string) sarifLocationURI {
+	if root := strings.TrimSuffix(sourceRoot, "/"); root != "" {
+		if rel, ok := strings.CutPrefix(name, root+"/"); ok {
+			name = rel
+		}
+	}
+	if path.IsAbs(name) {
+		return sarifLocationURI{URI: (&url.URL{Scheme: "file", Path: name}).String()}
+	}
+	return sarifLocationURI{URI: (&url.URL{Path: normalizeTreePath(name)}).String(), URIBaseID: sarifSourceRoot}
+}
+
+// loadSARIFGate loads the quality gate of a project, or nil when it has none
+func loadSARIFGate(db *gorm.DB, projectID string) (*models.QualityGate, error) {
+	var gate models.QualityGate
+	err := db.Preload("PathRules").Where("project_id = ?", projectID).First(&gate).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gate, nil
+}
+
+// sarifPathLevels returns the level of the uncovered lines of each file. A file
+// is held to the minimum of the most specific path rule of the gate that matches
+// it, or else to the minimum total coverage: its lines are errors when that
+// minimum is not met and warnings otherwise. Lines no rule applies to are notes.
+func sarifPathLevels(gate *models.QualityGate, files map[string]*fileCoverage) func(name string) string {
+	if gate == nil {
+		return func(string) string { return sarifLevelNote }
+	}
+
+	rules := append([]models.QualityGatePathRule(nil), gate.PathRules...)
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].Path) > len(rules[j].Path) })
+
+	levelFor := func(rate, minimum float64) string {
+		if rate < minimum {
+			return sarifLevelError
+		}
+		return sarifLevelWarning
+	}
+
+	ruleLevels := make(map[string]string)
+	for _, rule := range rules {
+		total, covered := 0, 0
+		for name, file := range files {
+			if pathRuleMatches(name, rule.Path) {
+				relevant, hit := file.counts()
+				total += relevant
+				covered += hit
+			}
+		}
+		ruleLevels[rule.Path] = levelFor(coverageRate(covered, total), rule.MinCoverage)
+	}
+
+	totalLevel := sarifLevelNote
+	if gate.MinCoverage != nil {
+		totalLevel = levelFor(totalCoverageRate(files), *gate.MinCoverage)
+	}
+
+	return func(name string) string {
+		for _, rule := range rules {
+			if pathRuleMatches(name, rule.Path) {
+				return ruleLevels[rule.Path]
+			}
+		}
+		return totalLevel
+	}
+}
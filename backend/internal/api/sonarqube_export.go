@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// sonarCoverage is the root element of a SonarQube generic test coverage report
+type sonarCoverage struct {
+	XMLName xml.Name    `xml:"coverage"`
+	Version int         `xml:"version,attr"`
+	Files   []sonarFile `xml:"file"`
+}
+
+// sonarFile lists the relevant lines of a file
+type sonarFile struct {
+	Path  string      `xml:"path,attr"`
+	Lines []sonarLine `xml:"lineToCover"`
+}
+
+// sonarLine is the coverage of a line, with its branches if it has any
+type sonarLine struct {
+	LineNumber      int  `xml:"lineNumber,attr"`
+	Covered         bool `xml:"covered,attr"`
+	BranchesToCover *int `xml:"branchesToCover,attr"`
+	CoveredBranches *int `xml:"coveredBranches,attr"`
+}
+
+// writeSonarQube writes coverage in the SonarQube generic test coverage format,
+// for the sonar.coverageReportPaths analysis parameter
+func writeSonarQube(w io.Writer, export *coverageExport) error {
+	report := sonarCoverage{Version: 1}
+
+	for _, name := range sortedFileNames(export.Files) {
+		file := export.Files[name]
+		branches := file.lineBranches()
+		sf := sonarFile{Path: name}
+		for i, hits := range file.Lines {
+			if hits == lineNotRelevant {
+				continue
+			}
+			line := sonarLine{LineNumber: i + 1, Covered: hits > 0}
+			if counts, ok := branches[i+1]; ok {
+				line.BranchesToCover, line.CoveredBranches = &counts[0], &counts[1]
+			}
+			sf.Lines = append(sf.Lines, line)
+		}
+		// Files without relevant lines have nothing to report
+		if len(sf.Lines) > 0 {
+			report.Files = append(report.Files, sf)
+		}
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
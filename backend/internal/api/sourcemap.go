@@ -0,0 +1,497 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSourceMapLine is the last original line a source map may point to when
+// it does not embed the content of the source
+const maxSourceMapLine = 1 << 20
+
+// sourceMappingURLPattern finds the source map comment of a bundled file
+var sourceMappingURLPattern = regexp.MustCompile(`//[#@]\s*sourceMappingURL=(\S+)`)
+
+// sourceMap is a source map (revision 3), either a regular map or an index map made of sections
+type sourceMap struct {
+	Version        int       `json:"version"`
+	SourceRoot     string    `json:"sourceRoot"`
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent"`
+	Mappings       string    `json:"mappings"`
+	Sections       []struct {
+		Offset struct {
+			Line   int `json:"line"`
+			Column int `json:"column"`
+		} `json:"offset"`
+		Map *sourceMap `json:"map"`
+	} `json:"sections"`
+}
+
+// sourceLine is a 1-based line of an original source, which is an index into lineMapping.Sources
+type sourceLine struct {
+	Source int
+	Line   int
+}
+
+// lineMapping maps the lines of a bundled file to the lines of its original sources
+type lineMapping struct {
+	Sources  []string             // Original file names, relative to the repository root
+	Contents []string             // Original file contents, when embedded in the map
+	Lines    map[int][]sourceLine // 1-based bundle line -> original lines, in mapping order
+}
+
+// decodeSourceMap decodes a source map of the bundled file name into line mappings
+func decodeSourceMap(data []byte, name string) (*lineMapping, error) {
+	var m sourceMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	mapping := &lineMapping{Lines: make(map[int][]sourceLine)}
+	if err := m.addLines(mapping, path.Dir(name), 0); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// addLines adds the mappings of a source map, shifted by a number of bundle lines, to a line mapping
+func (m *sourceMap) addLines(mapping *lineMapping, bundleDir string, lineOffset int) error {
+	if m.Version != 3 {
+		return fmt.Errorf("unsupported source map version %d", m.Version)
+	}
+	if len(m.Sections) > 0 {
+		for _, section := range m.Sections {
+			if section.Map == nil {
+				return fmt.Errorf("source map sections must embed their map")
+			}
+			if err := section.Map.addLines(mapping, bundleDir, lineOffset+section.Offset.Line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Sources of this map are appended to the ones of the previous sections.
+	// Mappings past the end of an embedded source, or past maxSourceMapLine
+	// without one, are dropped: remapped coverage grows up to the last line.
+	first := len(mapping.Sources)
+	lineCounts := make([]int, len(m.Sources))
+	for i, source := range m.Sources {
+		mapping.Sources = append(mapping.Sources, resolveSourceName(bundleDir, m.SourceRoot, source))
+		content := ""
+		lineCounts[i] = maxSourceMapLine
+		if i < len(m.SourcesContent) && m.SourcesContent[i] != nil {
+			content = *m.SourcesContent[i]
+			lineCounts[i] = strings.Count(content, "\n") + 1
+		}
+		mapping.Contents = append(mapping.Contents, content)
+	}
+
+	// Segments are [column, source, line, column, name], each relative to the
+	// previous segment. Only the source and original line matter here.
+	source, line := 0, 0
+	for i, group := range strings.Split(m.Mappings, ";") {
+		for _, segment := range strings.Split(group, ",") {
+			if segment == "" {
+				continue
+			}
+			fields, err := decodeVLQ(segment)
+			if err != nil {
+				return err
+			}
+			if len(fields) < 4 {
+				continue // No original position
+			}
+			source += fields[1]
+			line += fields[2]
+			if source < 0 || source >= len(m.Sources) || line < 0 {
+				return fmt.Errorf("invalid mapping %q on line %d", segment, i+1)
+			}
+			if line >= lineCounts[source] {
+				continue
+			}
+
+			bundleLine := lineOffset + i + 1
+			mapped := sourceLine{Source: first + source, Line: line + 1}
+			lines := mapping.Lines[bundleLine]
+			if len(lines) == 0 || lines[len(lines)-1] != mapped {
+				mapping.Lines[bundleLine] = append(lines, mapped)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeVLQ decodes the base64 VLQ fields of a mapping segment
+func decodeVLQ(segment string) ([]int, error) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+	var fields []int
+	value, shift := 0, 0
+	for i := 0; i < len(segment); i++ {
+		digit := strings.IndexByte(alphabet, segment[i])
+		if digit < 0 || shift > 30 {
+			return nil, fmt.Errorf("invalid mapping %q", segment)
+		}
+		value |= (digit & 0x1f) << shift
+		if digit&0x20 != 0 {
+			shift += 5
+			continue
+		}
+		// The lowest bit holds the sign
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, fmt.Errorf("invalid mapping %q", segment)
+	}
+	return fields, nil
+}
+
+// resolveSourceName turns a source of a map into a path relative to the
+// repository root. Relative sources are relative to the bundle directory,
+// and bundler URLs such as webpack://app/./src/a.ts keep the part after "/./".
+func resolveSourceName(bundleDir, sourceRoot, source string) string {
+	name := source
+	if sourceRoot != "" && !strings.Contains(source, "://") && !path.IsAbs(source) {
+		name = strings.TrimSuffix(sourceRoot, "/") + "/" + source
+	}
+
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+		if j := strings.Index(name, "/./"); j >= 0 {
+			name = name[j+3:]
+		}
+	} else if !path.IsAbs(name) {
+		name = path.Join(bundleDir, name)
+	}
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return strings.TrimPrefix(name, "./")
+}
+
+// isVendoredSource tells whether an original source is a dependency bundled with the application
+func isVendoredSource(name string) bool {
+	return strings.HasPrefix(name, "node_modules/") || strings.Contains(name, "/node_modules/")
+}
+
+// remapUploadSourceMaps replaces the bundled files of an upload that come with a
+// source map by the original sources they were built from. Maps are read from
+// the source_map field of a file, from an inline data URL at the end of its
+// source, or from the "source_maps" parts of a multipart upload, matched by
+// the sourceMappingURL of the bundle or by its name followed by ".map".
+func remapUploadSourceMaps(c *gin.Context, upload *CoverallsUpload) error {
+	parts, err := sourceMapParts(c)
+	if err != nil {
+		return err
+	}
+
+	remapped := false
+	files := make([]CoverallsSourceFile, 0, len(upload.SourceFiles))
+	for _, file := range upload.SourceFiles {
+		data, err := findSourceMap(file, parts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		if data == nil {
+			files = append(files, file)
+			continue
+		}
+
+		mapping, err := decodeSourceMap(data, file.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		files = append(files, remapSourceFile(file, mapping)...)
+		remapped = true
+	}
+
+	if remapped {
+		upload.SourceFiles = mergeSourceFiles(files)
+	}
+	return nil
+}
+
+// sourceMapParts reads the source maps sent as "source_maps" parts of a multipart upload, by file name
+func sourceMapParts(c *gin.Context) (map[string][]byte, error) {
+	if c.ContentType() != "multipart/form-data" {
+		return nil, nil
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make(map[string][]byte)
+	for _, header := range form.File["source_maps"] {
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		parts[path.Base(header.Filename)] = data
+	}
+	return parts, nil
+}
+
+// findSourceMap returns the source map of an uploaded file, or nil when it has none
+func findSourceMap(file CoverallsSourceFile, parts map[string][]byte) ([]byte, error) {
+	if len(file.SourceMap) > 0 && string(file.SourceMap) != "null" {
+		// Either the map itself or a string holding it, possibly as a data URL
+		if file.SourceMap[0] != '"' {
+			return file.SourceMap, nil
+		}
+		var value string
+		if err := json.Unmarshal(file.SourceMap, &value); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(value, "data:") {
+			return decodeDataURL(value)
+		}
+		return []byte(value), nil
+	}
+
+	reference := ""
+	if matches := sourceMappingURLPattern.FindAllStringSubmatch(file.Source, -1); len(matches) > 0 {
+		reference = matches[len(matches)-1][1]
+	}
+	if strings.HasPrefix(reference, "data:") {
+		return decodeDataURL(reference)
+	}
+	if reference != "" {
+		if data, ok := parts[path.Base(reference)]; ok {
+			return data, nil
+		}
+	}
+	if data, ok := parts[path.Base(file.Name)+".map"]; ok {
+		return data, nil
+	}
+	return nil, nil
+}
+
+// decodeDataURL decodes the content of a data URL, base64 or percent-encoded
+func decodeDataURL(value string) ([]byte, error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(value, "data:"), ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URL")
+	}
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decoded), nil
+}
+
+// remapSourceFile maps the coverage of a bundled file to its original sources.
+// An original line gets the highest count of the bundle lines mapped to it,
+// while branches and functions move to the first original line of their bundle
+// line. Dependencies under node_modules are dropped.
+func remapSourceFile(file CoverallsSourceFile, mapping *lineMapping) []CoverallsSourceFile {
+	files := make(map[int]*CoverallsSourceFile)
+	lines := make(map[int][]int)
+	target := func(source int) *CoverallsSourceFile {
+		if isVendoredSource(mapping.Sources[source]) {
+			return nil
+		}
+		f, ok := files[source]
+		if !ok {
+			f = &CoverallsSourceFile{Name: mapping.Sources[source], Source: mapping.Contents[source]}
+			files[source] = f
+		}
+		return f
+	}
+	firstLine := func(line int) (sourceLine, bool) {
+		mapped := mapping.Lines[line]
+		if len(mapped) == 0 {
+			return sourceLine{}, false
+		}
+		return mapped[0], true
+	}
+
+	for i, cov := range file.Coverage {
+		val, ok := cov.(float64)
+		if !ok {
+			continue
+		}
+		for _, mapped := range mapping.Lines[i+1] {
+			if target(mapped.Source) == nil {
+				continue
+			}
+			hits := lines[mapped.Source]
+			for len(hits) < mapped.Line {
+				hits = append(hits, lineNotRelevant)
+			}
+			hits[mapped.Line-1] = max(hits[mapped.Line-1], int(val))
+			lines[mapped.Source] = hits
+		}
+	}
+
+	for i := 0; i+3 < len(file.Branches); i += 4 {
+		mapped, ok := firstLine(file.Branches[i])
+		if !ok {
+			continue
+		}
+		if f := target(mapped.Source); f != nil {
+			f.Branches = append(f.Branches, mapped.Line, file.Branches[i+1], file.Branches[i+2], file.Branches[i+3])
+		}
+	}
+
+	for _, fn := range file.Functions {
+		start, ok := firstLine(fn.StartLine)
+		if !ok {
+			continue
+		}
+		f := target(start.Source)
+		if f == nil {
+			continue
+		}
+		end := start.Line
+		if mapped, ok := firstLine(fn.EndLine); ok && mapped.Source == start.Source && mapped.Line >= start.Line {
+			end = mapped.Line
+		}
+		f.Functions = append(f.Functions, UploadFunction{Name: fn.Name, StartLine: start.Line, EndLine: end, Hits: fn.Hits})
+	}
+
+	for key, labels := range file.Contexts {
+		line, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		for _, mapped := range mapping.Lines[line] {
+			f := target(mapped.Source)
+			if f == nil {
+				continue
+			}
+			if f.Contexts == nil {
+				f.Contexts = make(map[string][]string)
+			}
+			originalKey := strconv.Itoa(mapped.Line)
+			f.Contexts[originalKey] = appendMissing(f.Contexts[originalKey], labels...)
+		}
+	}
+
+	sources := make([]int, 0, len(files))
+	for source := range files {
+		sources = append(sources, source)
+	}
+	sort.Ints(sources)
+
+	remapped := make([]CoverallsSourceFile, 0, len(sources))
+	for _, source := range sources {
+		f := files[source]
+		f.Coverage = lineHitsToCoverage(lines[source])
+		remapped = append(remapped, *f)
+	}
+	return remapped
+}
+
+// mergeSourceFiles combines the uploaded files with the same name, which
+// happens when several bundles include the same original source. Hits add up
+// like those of several jobs.
+func mergeSourceFiles(files []CoverallsSourceFile) []CoverallsSourceFile {
+	merged := make([]CoverallsSourceFile, 0, len(files))
+	index := make(map[string]int, len(files))
+	for _, file := range files {
+		i, ok := index[file.Name]
+		if !ok {
+			index[file.Name] = len(merged)
+			merged = append(merged, file)
+			continue
+		}
+
+		m := &merged[i]
+		if m.Source == "" {
+			m.Source = file.Source
+		}
+		m.Coverage = lineHitsToCoverage(mergeLineHits(coverageToLineHits(m.Coverage), coverageToLineHits(file.Coverage)))
+		m.Branches = mergeBranches(m.Branches, file.Branches)
+		m.Functions = mergeUploadFunctions(m.Functions, file.Functions)
+		for key, labels := range file.Contexts {
+			if m.Contexts == nil {
+				m.Contexts = make(map[string][]string)
+			}
+			m.Contexts[key] = appendMissing(m.Contexts[key], labels...)
+		}
+	}
+	return merged
+}
+
+// mergeUploadFunctions combines the functions of the same file, adding up the hits of the same function
+func mergeUploadFunctions(a, b []UploadFunction) []UploadFunction {
+	merged := append([]UploadFunction(nil), a...)
+	for _, fn := range b {
+		found := false
+		for i := range merged {
+			if merged[i].Name == fn.Name && merged[i].StartLine == fn.StartLine {
+				merged[i].Hits += fn.Hits
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, fn)
+		}
+	}
+	return merged
+}
+
+// coverageToLineHits converts an uploaded coverage array into per-line hit counts, like parseLineHits
+func coverageToLineHits(coverage []interface{}) []int {
+	hits := make([]int, len(coverage))
+	for i, v := range coverage {
+		hits[i] = lineNotRelevant
+		if val, ok := v.(float64); ok {
+			hits[i] = int(val)
+		}
+	}
+	return hits
+}
+
+// lineHitsToCoverage converts per-line hit counts back into an uploaded coverage array
+func lineHitsToCoverage(hits []int) []interface{} {
+	coverage := make([]interface{}, len(hits))
+	for i, v := range hits {
+		if v != lineNotRelevant {
+			coverage[i] = float64(v)
+		}
+	}
+	return coverage
+}
+
+// appendMissing appends the values that are not in the slice yet
+func appendMissing(values []string, more ...string) []string {
+	for _, v := range more {
+		found := false
+		for _, existing := range values {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// testSourceMap maps dist/app.js line 1 to src/main.ts line 1, line 2 to
+// src/main.ts lines 3 and 4, and line 3 to a bundled dependency
+const testSourceMap = `{
+  "version": 3,
+  "file": "app.js",
+  "sources": ["webpack://app/./src/main.ts", "webpack://app/./node_modules/lib/index.js"],
+  "sourcesContent": ["const a = 1\n\nif (a) {\n  run()\n}\n", null],
+  "mappings": "AAAA;AAEA,UACA;ACHA"
+}`
+
+func TestDecodeVLQ(t *testing.T) {
+	fields, err := decodeVLQ("UACHgB")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []int{10, 0, 1, -3, 16}
+	if fmt.Sprint(fields) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+
+	if _, err := decodeVLQ("A!"); err == nil {
+		t.Error("Expected an error for an invalid character")
+	}
+}
+
+// encodeVLQ encodes the fields of a mapping segment
+func encodeVLQ(fields ...int) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+	var b strings.Builder
+	for _, field := range fields {
+		value := field << 1
+		if field < 0 {
+			value = -field<<1 | 1
+		}
+		for {
+			digit := value & 0x1f
+			value >>= 5
+			if value > 0 {
+				digit |= 0x20
+			}
+			b.WriteByte(alphabet[digit])
+			if value == 0 {
+				break
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestDecodeSourceMapDropsLinesPastSources(t *testing.T) {
+	// a.ts has 2 lines; b.ts has no embedded content
+	mappings := strings.Join([]string{
+		encodeVLQ(0, 0, 0, 0) + "," + encodeVLQ(1, 0, 5, 0),                             // a.ts lines 1 and 6
+		encodeVLQ(0, 1, 1_000_000_000-5, 0) + "," + encodeVLQ(1, 0, 2-1_000_000_000, 0), // b.ts lines 1000000001 and 3
+	}, ";")
+	data, _ := json.Marshal(map[string]interface{}{
+		"version":        3,
+		"sources":        []string{"a.ts", "b.ts"},
+		"sourcesContent": []interface{}{"one\ntwo", nil},
+		"mappings":       mappings,
+	})
+
+	mapping, err := decodeSourceMap(data, "app.js")
+	if err != nil {
+		t.Fatalf("Failed to decode source map: %v", err)
+	}
+	expected := map[int][]sourceLine{1: {{Source: 0, Line: 1}}, 2: {{Source: 1, Line: 3}}}
+	if fmt.Sprint(mapping.Lines) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, mapping.Lines)
+	}
+
+	files := remapSourceFile(CoverallsSourceFile{Name: "app.js", Coverage: []interface{}{1.0, 2.0}}, mapping)
+	for _, f := range files {
+		if len(f.Coverage) > 3 {
+			t.Errorf("Expected the coverage of %s to stop at its last mapped line, got %d lines", f.Name, len(f.Coverage))
+		}
+	}
+}
+
+func TestResolveSourceName(t *testing.T) {
+	tests := []struct {
+		dir, root, source, expected string
+	}{
+		{"dist", "", "webpack://app/./src/main.ts", "src/main.ts"},
+		{"dist", "", "webpack:///src/App.vue", "src/App.vue"},
+		{"dist", "", "../src/main.ts", "src/main.ts"},
+		{"public/js", "../../", "src/a.ts", "src/a.ts"},
+		{".", "", "./src/a.ts", "src/a.ts"},
+	}
+	for _, tt := range tests {
+		if got := resolveSourceName(tt.dir, tt.root, tt.source); got != tt.expected {
+			t.Errorf("resolveSourceName(%q, %q, %q) = %q, expected %q", tt.dir, tt.root, tt.source, got, tt.expected)
+		}
+	}
+}
+
+func TestUploadWithSourceMaps(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Bundled", Token: "bundled-token"}
+	db.Create(&project)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/v2", NewJobHandler(db).Upload)
+
+	// The second bundle embeds its map and includes src/main.ts line 1 as well
+	inline := base64.StdEncoding.EncodeToString([]byte(`{"version":3,"sources":["../src/main.ts"],"mappings":"AAAA"}`))
+	upload := fmt.Sprintf(`{
+		"repo_token": "bundled-token",
+		"source_files": [
+			{"name": "dist/app.js", "coverage": [1, 0, 5], "source_map": %s,
+			 "functions": [{"name": "run", "start_line": 2, "end_line": 2, "hits": 0}],
+			 "contexts": {"1": ["e2e login"]}},
+			{"name": "dist/other.js", "coverage": [2],
+			 "source": "x()\n//# sourceMappingURL=data:application/json;base64,%s"},
+			{"name": "src/util.ts", "coverage": [1, null]}
+		]
+	}`, testSourceMap, inline)
+
+	req := httptest.NewRequest("POST", "/upload/v2", strings.NewReader(upload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var result struct {
+		JobID uint `json:"job_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+
+	var files []models.JobFile
+	db.Preload("Functions").Where("job_id = ?", result.JobID).Order("name").Find(&files)
+	if len(files) != 2 || files[0].Name != "src/main.ts" || files[1].Name != "src/util.ts" {
+		t.Fatalf("Expected the bundles to be replaced by src/main.ts, got %+v", files)
+	}
+
+	main := files[0]
+	if main.Coverage != `[3,null,0,0]` {
+		t.Errorf("Expected hits of both bundles on line 1 and none on lines 3-4, got %s", main.Coverage)
+	}
+	if !strings.HasPrefix(main.Source, "const a = 1") {
+		t.Errorf("Expected the original source from sourcesContent, got %q", main.Source)
+	}
+	if len(main.Functions) != 1 || main.Functions[0].StartLine != 3 {
+		t.Errorf("Expected run to start on line 3, got %+v", main.Functions)
+	}
+	if main.Contexts != `{"1":["e2e login"]}` {
+		t.Errorf("Expected contexts on line 1, got %s", main.Contexts)
+	}
+
+	// Maps may also be sent as separate parts of a goveralls-style multipart upload
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("json", `{"repo_token": "bundled-token", "source_files": [{"name": "dist/app.js", "coverage": [4, 1, 1]}]}`)
+	part, _ := writer.CreateFormFile("source_maps", "app.js.map")
+	part.Write([]byte(testSourceMap))
+	writer.Close()
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/upload/v2", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+
+	var file models.JobFile
+	if err := db.Where("job_id = ?", result.JobID).First(&file).Error; err != nil || file.Name != "src/main.ts" || file.Coverage != `[4,null,1,1]` {
+		t.Errorf("Expected src/main.ts remapped from the separate map, got %+v", file)
+	}
+
+	// Broken maps are rejected instead of storing bundle lines
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/upload/v2", strings.NewReader(`{"repo_token": "bundled-token", "source_files": [
+		{"name": "dist/app.js", "coverage": [1], "source_map": {"version": 3, "sources": [], "mappings": "AAAA"}}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid map, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sparkline sizes, in pixels
+const (
+	defaultSparklineWidth  = 120
+	defaultSparklineHeight = 20
+	maxSparklineSize       = 1000
+	sparklinePadding       = 2.0 // Keeps the stroke and the end dot inside the image
+)
+
+// GetSparkline draws the coverage of the recent builds of a branch as a small trend graphic
+//
+//	@Summary		Coverage sparkline
+//	@Description	Get an SVG sparkline of the coverage of the last builds of a branch, for wikis and developer portals. The line is scaled to the range of the values and colored like the badge of the latest build.
+//	@Tags			badges
+//	@Produce		image/svg+xml
+//	@Param			id		path		string	true	"Project ID"
+//	@Param			branch	query		string	false	"Branch (defaults to the project's current branch)"
+//	@Param			token	query		string	false	"Badge token, required when the project has private badges"
+//	@Param			builds	query		int		false	"Number of recent builds (default 30, max 200)"
+//	@Param			width	query		int		false	"Width in pixels (default 120)"
+//	@Param			height	query		int		false	"Height in pixels (default 20)"
+//	@Success		200		{string}	string	"SVG sparkline"
+//	@Success		304		{string}	string	"Not modified"
+//	@Failure		400		{string}	string	"Invalid parameter"
+//	@Failure		404		{string}	string	"Project not found"
+//	@Failure		500		{string}	string	"Database error"
+//	@Router			/projects/{id}/sparkline.svg [get]
+func (h *BadgeHandler) GetSparkline(c *gin.Context) {
+	builds, err := strconv.Atoi(c.DefaultQuery("builds", "30"))
+	if err != nil || builds < 1 {
+		c.String(http.StatusBadRequest, "Invalid builds")
+		return
+	}
+	builds = min(builds, 200)
+
+	width, err := strconv.Atoi(c.DefaultQuery("width", strconv.Itoa(defaultSparklineWidth)))
+	if err != nil || width < 10 || width > maxSparklineSize {
+		c.String(http.StatusBadRequest, "Invalid width")
+		return
+	}
+	height, err := strconv.Atoi(c.DefaultQuery("height", strconv.Itoa(defaultSparklineHeight)))
+	if err != nil || height < 10 || height > maxSparklineSize {
+		c.String(http.StatusBadRequest, "Invalid height")
+		return
+	}
+
+	project, ok := h.findBadgeProject(c)
+	if !ok {
+		return
+	}
+
+	branch := c.Query("branch")
+	if branch == "" {
+		branch = project.CurrentBranch
+	}
+
+	recent, err := recentBuilds(h.db, project.ID, branch, builds)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	rates := make([]float64, len(recent))
+	for i, b := range recent {
+		rates[i] = b.CoverageRate
+	}
+
+	color := badgeUnknownColor
+	if len(rates) > 0 {
+		low, high := badgeThresholds(project)
+		color = badgeColor(rates[len(rates)-1], low, high)
+	}
+
+	writeBadge(c, project, "image/svg+xml; charset=utf-8", []byte(renderSparkline(rates, width, height, color)))
+}
+
+// renderSparkline draws values oldest first as a line scaled to their range, with a dot on the latest one
+func renderSparkline(rates []float64, width, height int, color string) string {
+	title := "coverage: no builds"
+	if n := len(rates); n > 0 {
+		title = fmt.Sprintf("coverage: %s over %d builds", formatBadgeRate(rates[n-1]), n)
+		if n > 1 {
+			title = fmt.Sprintf("coverage: %s to %s over %d builds", formatBadgeRate(rates[0]), formatBadgeRate(rates[n-1]), n)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s">`,
+		width, height, width, height, html.EscapeString(title))
+	fmt.Fprintf(&b, `<title>%s</title>`, html.EscapeString(title))
+
+	if len(rates) == 0 {
+		fmt.Fprintf(&b, `<line x1="%g" y1="%g" x2="%g" y2="%g" stroke="%s" stroke-width="1" stroke-dasharray="2,2"/></svg>`,
+			sparklinePadding, float64(height)/2, float64(width)-sparklinePadding, float64(height)/2, color)
+		return b.String()
+	}
+
+	lowest, highest := rates[0], rates[0]
+	for _, r := range rates {
+		lowest, highest = math.Min(lowest, r), math.Max(highest, r)
+	}
+
+	innerWidth := float64(width) - 2*sparklinePadding
+	innerHeight := float64(height) - 2*sparklinePadding
+	points := make([]string, len(rates))
+	var x, y float64
+	for i, r := range rates {
+		x = sparklinePadding + innerWidth
+		if len(rates) > 1 {
+			x = sparklinePadding + innerWidth*float64(i)/float64(len(rates)-1)
+		}
+		// Flat series sit in the middle
+		y = sparklinePadding + innerHeight/2
+		if highest > lowest {
+			y = sparklinePadding + innerHeight*(highest-r)/(highest-lowest)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	if len(rates) == 1 {
+		points = append([]string{fmt.Sprintf("%.1f,%.1f", sparklinePadding, y)}, points...)
+	}
+
+	bottom := float64(height) - sparklinePadding
+	fmt.Fprintf(&b, `<polygon points="%s %.1f,%.1f %.1f,%.1f" fill="%s" fill-opacity=".15"/>`,
+		strings.Join(points, " "), x, bottom, sparklinePadding, bottom, color)
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5" stroke-linejoin="round" stroke-linecap="round"/>`,
+		strings.Join(points, " "), color)
+	fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="%g" fill="%s"/>`, x, y, sparklinePadding, color)
+	b.WriteString(`</svg>`)
+
+	return b.String()
+}
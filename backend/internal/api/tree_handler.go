@@ -0,0 +1,199 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TreeNode is a directory or file in a coverage tree
+type TreeNode struct {
+	Name          string  `json:"name"`
+	Path          string  `json:"path"`
+	Type          string  `json:"type"` // dir or file
+	Files         int     `json:"files"`
+	RelevantLines int     `json:"relevant_lines"`
+	CoveredLines  int     `json:"covered_lines"`
+	CoverageRate  float64 `json:"coverage_rate"`
+}
+
+// CoverageTree is the coverage of a directory and its direct children
+type CoverageTree struct {
+	Path          string     `json:"path"`
+	Files         int        `json:"files"`
+	RelevantLines int        `json:"relevant_lines"`
+	CoveredLines  int        `json:"covered_lines"`
+	CoverageRate  float64    `json:"coverage_rate"`
+	Children      []TreeNode `json:"children"`
+}
+
+// Tree returns the coverage of a build rolled up by directory
+//
+//	@Summary		Build coverage tree
+//	@Description	Get the coverage of a directory of a build and of its direct children
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Build ID"
+//	@Param			path	query		string	false	"Directory to expand (defaults to the repository root)"
+//	@Param			sort	query		string	false	"name (default) or coverage (worst first)"
+//	@Success		200		{object}	CoverageTree
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/builds/{id}/tree [get]
+func (h *BuildHandler) Tree(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid build ID"})
+		return
+	}
+
+	if err := h.db.Select("id").First(&models.Build{}, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Build not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch build"})
+		}
+		return
+	}
+
+	files, err := loadBuildCoverage(h.db, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+
+	respondCoverageTree(c, files)
+}
+
+// Tree returns the coverage of a job rolled up by directory
+//
+//	@Summary		Job coverage tree
+//	@Description	Get the coverage of a directory of a job and of its direct children
+//	@Tags			jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Job ID"
+//	@Param			path	query		string	false	"Directory to expand (defaults to the repository root)"
+//	@Param			sort	query		string	false	"name (default) or coverage (worst first)"
+//	@Success		200		{object}	CoverageTree
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/jobs/{id}/tree [get]
+func (h *JobHandler) Tree(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	if err := h.db.Select("id").First(&models.Job{}, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		}
+		return
+	}
+
+	files, err := loadJobCoverage(h.db, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+
+	respondCoverageTree(c, files)
+}
+
+// respondCoverageTree writes the tree of the directory requested by the path and sort query parameters
+func respondCoverageTree(c *gin.Context, files map[string]*fileCoverage) {
+	sortBy := c.DefaultQuery("sort", "name")
+	if sortBy != "name" && sortBy != "coverage" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be name or coverage"})
+		return
+	}
+
+	tree := buildCoverageTree(files, c.Query("path"))
+	sortTreeNodes(tree.Children, sortBy)
+
+	c.JSON(http.StatusOK, tree)
+}
+
+// normalizeTreePath trims leading "./" and slashes so that paths can be split into directories
+func normalizeTreePath(p string) string {
+	p = strings.TrimPrefix(p, "./")
+	return strings.Trim(p, "/")
+}
+
+// buildCoverageTree rolls up the files under a directory into its direct children
+func buildCoverageTree(files map[string]*fileCoverage, dir string) CoverageTree {
+	dir = normalizeTreePath(dir)
+	tree := CoverageTree{Path: dir, Children: []TreeNode{}}
+
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	children := make(map[string]*TreeNode)
+	for name, file := range files {
+		rel := normalizeTreePath(name)
+		if !strings.HasPrefix(rel, prefix) {
+			continue
+		}
+		rel = strings.TrimPrefix(rel, prefix)
+
+		childName, nodeType := rel, "file"
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			childName, nodeType = rel[:i], "dir"
+		}
+
+		node, ok := children[childName]
+		if !ok {
+			node = &TreeNode{Name: childName, Path: prefix + childName, Type: nodeType}
+			children[childName] = node
+		}
+
+		relevant, covered := file.counts()
+		node.Files++
+		node.RelevantLines += relevant
+		node.CoveredLines += covered
+		tree.Files++
+		tree.RelevantLines += relevant
+		tree.CoveredLines += covered
+	}
+
+	for _, node := range children {
+		node.CoverageRate = coverageRate(node.CoveredLines, node.RelevantLines)
+		tree.Children = append(tree.Children, *node)
+	}
+	tree.CoverageRate = coverageRate(tree.CoveredLines, tree.RelevantLines)
+
+	return tree
+}
+
+// sortTreeNodes sorts nodes by name, directories first, or by coverage, worst first
+func sortTreeNodes(nodes []TreeNode, sortBy string) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if sortBy == "coverage" && nodes[i].CoverageRate != nodes[j].CoverageRate {
+			return nodes[i].CoverageRate < nodes[j].CoverageRate
+		}
+		if sortBy == "coverage" && nodes[i].RelevantLines != nodes[j].RelevantLines {
+			// Among equally covered nodes, the bigger ones matter most
+			return nodes[i].RelevantLines > nodes[j].RelevantLines
+		}
+		if nodes[i].Type != nodes[j].Type {
+			return nodes[i].Type == "dir"
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+}
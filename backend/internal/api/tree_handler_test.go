@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBuildCoverageTree(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Tree", Token: "tree-token"}
+	db.Create(&project)
+	build := createTestBuild(t, db, project.ID, 1, "main", "sha", map[string]string{
+		"main.go":              `[1, 1]`,
+		"pkg/api/handler.go":   `[1, 0, 0, 0]`,
+		"pkg/api/routes.go":    `[1, 1, null]`,
+		"pkg/models/models.go": `[1, 1, 1, 1]`,
+	})
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/builds/:id/tree", NewBuildHandler(db).Tree)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/builds/%d/tree?path=pkg/&sort=coverage", build.ID), nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var tree CoverageTree
+	json.Unmarshal(w.Body.Bytes(), &tree)
+
+	if tree.Path != "pkg" || tree.Files != 3 || tree.RelevantLines != 10 || tree.CoveredLines != 7 {
+		t.Errorf("Unexpected totals for pkg: %+v", tree)
+	}
+	if len(tree.Children) != 2 {
+		t.Fatalf("Expected 2 children, got %+v", tree.Children)
+	}
+	// Worst coverage first: pkg/api has 3 of 6 lines covered
+	if tree.Children[0].Path != "pkg/api" || tree.Children[0].Type != "dir" || tree.Children[0].CoverageRate != 50 {
+		t.Errorf("Expected pkg/api first at 50%%, got %+v", tree.Children[0])
+	}
+}
+
+func TestBuildCoverageTreeRoot(t *testing.T) {
+	files := map[string]*fileCoverage{
+		"main.go":    {Name: "main.go", Lines: []int{1}},
+		"pkg/a.go":   {Name: "pkg/a.go", Lines: []int{0}},
+		"./pkg/b.go": {Name: "./pkg/b.go", Lines: []int{1}},
+	}
+
+	tree := buildCoverageTree(files, "")
+	sortTreeNodes(tree.Children, "name")
+
+	if len(tree.Children) != 2 || tree.Children[0].Name != "pkg" || tree.Children[0].Files != 2 {
+		t.Errorf("Expected pkg directory first with 2 files, got %+v", tree.Children)
+	}
+	if tree.Children[1].Type != "file" || tree.Children[1].Path != "main.go" {
+		t.Errorf("Expected main.go file, got %+v", tree.Children[1])
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrendPoint is a coverage sample of a branch. For bucketed series the
+// timestamp is the start of the bucket and the build is the one selected by
+// the aggregate (the latest build for avg).
+type TrendPoint struct {
+	Timestamp    time.Time `json:"timestamp"`
+	BuildNum     int       `json:"build_num"`
+	LineRate     float64   `json:"line_rate"`
+	BranchRate   *float64  `json:"branch_rate,omitempty"`
+	Tests        *int      `json:"tests,omitempty"`         // Only set when test results were uploaded
+	TestDuration *float64  `json:"test_duration,omitempty"` // Seconds
+	Builds       int       `json:"builds,omitempty"`        // Number of builds in the bucket
+}
+
+// TrendSeries is the coverage history of a single branch
+type TrendSeries struct {
+	Branch string       `json:"branch"`
+	Points []TrendPoint `json:"points"`
+}
+
+// CoverageTrend is the coverage history of a project
+type CoverageTrend struct {
+	ProjectID string        `json:"project_id"`
+	Interval  string        `json:"interval"`
+	Aggregate string        `json:"aggregate"`
+	Series    []TrendSeries `json:"series"`
+}
+
+// trendSample is the subset of build columns needed for trends
+type trendSample struct {
+	BuildNum           int
+	Branch             string
+	CreatedAt          time.Time
+	CoverageRate       float64
+	BranchCoverageRate *float64
+	TestCount          *int
+	TestDuration       *float64
+}
+
+// Trend returns the coverage time series of a project
+//
+//	@Summary		Coverage trend
+//	@Description	Get the coverage time series of a project per branch, with test counts and durations when test results were uploaded, optionally bucketed by day, week or month
+//	@Tags			builds
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string	true	"Project ID"
+//	@Param			branch		query		string	false	"Only return this branch"
+//	@Param			from		query		string	false	"Start date (RFC 3339 or YYYY-MM-DD)"
+//	@Param			to			query		string	false	"End date, inclusive (RFC 3339 or YYYY-MM-DD)"
+//	@Param			interval	query		string	false	"Bucket size: none (default), day, week or month"
+//	@Param			aggregate	query		string	false	"Bucket aggregate: last (default), min, max or avg"
+//	@Success		200			{object}	CoverageTrend
+//	@Failure		400			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		BearerAuth
+//	@Router			/api/v1/projects/{id}/trend [get]
+func (h *BuildHandler) Trend(c *gin.Context) {
+	projectID := c.Param("id")
+
+	interval := c.DefaultQuery("interval", "none")
+	if interval != "none" && interval != "day" && interval != "week" && interval != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be none, day, week or month"})
+		return
+	}
+	aggregate := c.DefaultQuery("aggregate", "last")
+	if aggregate != "last" && aggregate != "min" && aggregate != "max" && aggregate != "avg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "aggregate must be last, min, max or avg"})
+		return
+	}
+
+	query := h.db.Table("builds").
+		Select("build_num, branch, created_at, coverage_rate, branch_coverage_rate, test_count, test_duration").
+		Where("project_id = ? AND deleted_at IS NULL", projectID)
+
+	if branch := c.Query("branch"); branch != "" {
+		query = query.Where("branch = ?", branch)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTrendDate(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTrendDate(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+			return
+		}
+		query = query.Where("created_at <= ?", t)
+	}
+
+	var samples []trendSample
+	if err := query.Order("created_at ASC, build_num ASC").Scan(&samples).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch builds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CoverageTrend{
+		ProjectID: projectID,
+		Interval:  interval,
+		Aggregate: aggregate,
+		Series:    buildTrendSeries(samples, interval, aggregate),
+	})
+}
+
+// parseTrendDate parses an RFC 3339 timestamp or a date. A date used as an
+// upper bound covers the whole day.
+func parseTrendDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// buildTrendSeries groups samples (ordered by time) per branch and buckets them
+func buildTrendSeries(samples []trendSample, interval, aggregate string) []TrendSeries {
+	byBranch := make(map[string][]trendSample)
+	for _, s := range samples {
+		byBranch[s.Branch] = append(byBranch[s.Branch], s)
+	}
+
+	branches := make([]string, 0, len(byBranch))
+	for branch := range byBranch {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+
+	series := make([]TrendSeries, 0, len(branches))
+	for _, branch := range branches {
+		series = append(series, TrendSeries{
+			Branch: branch,
+			Points: bucketTrend(byBranch[branch], interval, aggregate),
+		})
+	}
+	return series
+}
+
+// bucketTrend aggregates the samples of a branch into one point per bucket
+func bucketTrend(samples []trendSample, interval, aggregate string) []TrendPoint {
+	points := []TrendPoint{}
+	if interval == "none" {
+		for _, s := range samples {
+			points = append(points, TrendPoint{
+				Timestamp:    s.CreatedAt,
+				BuildNum:     s.BuildNum,
+				LineRate:     s.CoverageRate,
+				BranchRate:   s.BranchCoverageRate,
+				Tests:        s.TestCount,
+				TestDuration: s.TestDuration,
+			})
+		}
+		return points
+	}
+
+	for start := 0; start < len(samples); {
+		bucket := bucketStart(samples[start].CreatedAt, interval)
+		end := start
+		for end < len(samples) && bucketStart(samples[end].CreatedAt, interval).Equal(bucket) {
+			end++
+		}
+		point := aggregateTrend(samples[start:end], aggregate)
+		point.Timestamp = bucket
+		points = append(points, point)
+		start = end
+	}
+	return points
+}
+
+// bucketStart truncates a time to the start of its day, ISO week or month in UTC
+func bucketStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case "week":
+		offset := (int(day.Weekday()) + 6) % 7 // Monday is the first day of the week
+		return day.AddDate(0, 0, -offset)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// aggregateTrend reduces the samples of a bucket to a single point
+func aggregateTrend(samples []trendSample, aggregate string) TrendPoint {
+	pick := samples[len(samples)-1]
+	for _, s := range samples {
+		switch {
+		case aggregate == "min" && s.CoverageRate < pick.CoverageRate,
+			aggregate == "max" && s.CoverageRate > pick.CoverageRate:
+			pick = s
+		}
+	}
+
+	point := TrendPoint{
+		BuildNum:     pick.BuildNum,
+		LineRate:     pick.CoverageRate,
+		BranchRate:   pick.BranchCoverageRate,
+		Tests:        pick.TestCount,
+		TestDuration: pick.TestDuration,
+		Builds:       len(samples),
+	}
+
+	if aggregate == "avg" {
+		lineSum, branchSum, branchCount := 0.0, 0.0, 0
+		testSum, durationSum, testCount := 0, 0.0, 0
+		for _, s := range samples {
+			lineSum += s.CoverageRate
+			if s.BranchCoverageRate != nil {
+				branchSum += *s.BranchCoverageRate
+				branchCount++
+			}
+			if s.TestCount != nil {
+				testSum += *s.TestCount
+				if s.TestDuration != nil {
+					durationSum += *s.TestDuration
+				}
+				testCount++
+			}
+		}
+		point.LineRate = lineSum / float64(len(samples))
+		point.BranchRate = nil
+		if branchCount > 0 {
+			avg := branchSum / float64(branchCount)
+			point.BranchRate = &avg
+		}
+		point.Tests, point.TestDuration = nil, nil
+		if testCount > 0 {
+			tests := int(math.Round(float64(testSum) / float64(testCount)))
+			duration := durationSum / float64(testCount)
+			point.Tests, point.TestDuration = &tests, &duration
+		}
+	}
+
+	return point
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Frantche/Librecov/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBucketTrend(t *testing.T) {
+	day := func(d, h int) time.Time { return time.Date(2025, 3, d, h, 0, 0, 0, time.UTC) }
+	samples := []trendSample{
+		{BuildNum: 1, CreatedAt: day(3, 9), CoverageRate: 70},  // Monday
+		{BuildNum: 2, CreatedAt: day(3, 18), CoverageRate: 80}, // Monday
+		{BuildNum: 3, CreatedAt: day(9, 12), CoverageRate: 60}, // Sunday, same ISO week
+		{BuildNum: 4, CreatedAt: day(10, 8), CoverageRate: 90}, // next Monday
+	}
+
+	daily := bucketTrend(samples, "day", "last")
+	if len(daily) != 3 || daily[0].BuildNum != 2 || daily[0].Builds != 2 {
+		t.Errorf("Expected 3 daily buckets with build 2 last on day one, got %+v", daily)
+	}
+
+	weekly := bucketTrend(samples, "week", "min")
+	if len(weekly) != 2 || weekly[0].BuildNum != 3 || !weekly[0].Timestamp.Equal(day(3, 0)) {
+		t.Errorf("Expected 2 weekly buckets starting Monday with build 3 as minimum, got %+v", weekly)
+	}
+
+	monthly := bucketTrend(samples, "month", "avg")
+	if len(monthly) != 1 || monthly[0].LineRate != 75 {
+		t.Errorf("Expected a single monthly bucket averaging 75, got %+v", monthly)
+	}
+}
+
+func TestTrendEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Trend", Token: "trend-token"}
+	db.Create(&project)
+
+	branchRate := 50.0
+	builds := []models.Build{
+		{ProjectID: project.ID, BuildNum: 1, Branch: "main", CoverageRate: 70, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ProjectID: project.ID, BuildNum: 2, Branch: "main", CoverageRate: 75, BranchCoverageRate: &branchRate, CreatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ProjectID: project.ID, BuildNum: 3, Branch: "dev", CoverageRate: 65, CreatedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for i := range builds {
+		db.Create(&builds[i])
+	}
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.GET("/projects/:id/trend", NewBuildHandler(db).Trend)
+
+	req := httptest.NewRequest("GET", "/projects/"+project.ID+"/trend?from=2024-06-01", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var trend CoverageTrend
+	json.Unmarshal(w.Body.Bytes(), &trend)
+	if len(trend.Series) != 2 {
+		t.Fatalf("Expected a series per branch, got %+v", trend.Series)
+	}
+	main := trend.Series[1]
+	if main.Branch != "main" || len(main.Points) != 1 || main.Points[0].BuildNum != 2 {
+		t.Errorf("Expected main to only contain build 2, got %+v", main)
+	}
+	if main.Points[0].BranchRate == nil || *main.Points[0].BranchRate != 50 {
+		t.Errorf("Expected branch rate 50, got %v", main.Points[0].BranchRate)
+	}
+}
@@ -23,9 +23,18 @@ func setupTestDB() (*gorm.DB, error) {
 	err = db.AutoMigrate(
 		&models.User{},
 		&models.Project{},
+		&models.BadgeToken{},
 		&models.Build{},
 		&models.Job{},
 		&models.JobFile{},
+		&models.FileFunction{},
+		&models.QualityGate{},
+		&models.QualityGatePathRule{},
+		&models.FlakyLine{},
+		&models.FunctionComplexity{},
+		&models.TestSuite{},
+		&models.TestCase{},
+		&models.Mutant{},
 	)
 	if err != nil {
 		return nil, err
@@ -112,6 +121,13 @@ func TestUploadCoverageSuccess(t *testing.T) {
 		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
 	}
 
+	// Projects without a quality gate report the same status as the gate endpoint
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["gate_status"] != GateStatusNone {
+		t.Errorf("Expected gate status %q, got %v", GateStatusNone, response["gate_status"])
+	}
+
 	// Verify database records
 	var builds []models.Build
 	db.Find(&builds)
@@ -217,3 +233,107 @@ func TestUploadCoverageInvalidJSON(t *testing.T) {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
 	}
 }
+
+func TestUploadCoverageStoresCIMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "CI Project", Token: "ci-project-token"}
+	if err := db.Create(&project).Error; err != nil {
+		t.Fatalf("Failed to create test project: %v", err)
+	}
+
+	coverageData := map[string]interface{}{
+		"repo_token":        "ci-project-token",
+		"service_name":      "github-actions",
+		"service_number":    "412",
+		"service_job_id":    "412.2",
+		"service_build_url": "https://ci.example.com/runs/412",
+		"service_job_url":   "https://ci.example.com/runs/412/jobs/2",
+		"source_files": []map[string]interface{}{
+			{"name": "main.go", "coverage": []interface{}{1, 0}},
+		},
+	}
+	jsonData, _ := json.Marshal(coverageData)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/v2", NewJobHandler(db).Upload)
+
+	req := httptest.NewRequest("POST", "/upload/v2", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var build models.Build
+	db.First(&build)
+	if build.ServiceName != "github-actions" || build.ServiceNumber != "412" {
+		t.Errorf("Expected CI provider and run number on build, got %q/%q", build.ServiceName, build.ServiceNumber)
+	}
+	if build.ServiceBuildURL != "https://ci.example.com/runs/412" {
+		t.Errorf("Expected build URL to be stored, got %q", build.ServiceBuildURL)
+	}
+
+	var job models.Job
+	db.First(&job)
+	if job.JobNumber != "412.2" {
+		t.Errorf("Expected job number 412.2, got %q", job.JobNumber)
+	}
+	if job.ServiceJobURL != "https://ci.example.com/runs/412/jobs/2" {
+		t.Errorf("Expected job URL to be stored, got %q", job.ServiceJobURL)
+	}
+}
+
+func TestUploadCoverageStoresBranchCoverage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	project := models.Project{Name: "Branch Project", Token: "branch-project-token"}
+	if err := db.Create(&project).Error; err != nil {
+		t.Fatalf("Failed to create test project: %v", err)
+	}
+
+	// Branches are [line, block, branch, hits] quadruples
+	coverageData := map[string]interface{}{
+		"repo_token": "branch-project-token",
+		"source_files": []map[string]interface{}{
+			{"name": "main.go", "coverage": []interface{}{1, 0}, "branches": []int{1, 0, 0, 2, 1, 0, 1, 0}},
+		},
+	}
+	jsonData, _ := json.Marshal(coverageData)
+
+	w := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(w)
+	router.POST("/upload/v2", NewJobHandler(db).Upload)
+
+	req := httptest.NewRequest("POST", "/upload/v2", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var build models.Build
+	db.First(&build)
+	if build.BranchCoverageRate == nil || *build.BranchCoverageRate != 50 {
+		t.Errorf("Expected build branch coverage rate 50, got %v", build.BranchCoverageRate)
+	}
+
+	var file models.JobFile
+	db.First(&file)
+	if file.BranchCoverageRate == nil || *file.BranchCoverageRate != 50 {
+		t.Errorf("Expected file branch coverage rate 50, got %v", file.BranchCoverageRate)
+	}
+}
@@ -65,9 +65,18 @@ func runMigrations(db *gorm.DB) error {
 		&models.Project{},
 		&models.ProjectShare{},
 		&models.ProjectToken{},
+		&models.BadgeToken{},
 		&models.Build{},
 		&models.Job{},
 		&models.JobFile{},
+		&models.FileFunction{},
+		&models.QualityGate{},
+		&models.QualityGatePathRule{},
+		&models.FlakyLine{},
+		&models.FunctionComplexity{},
+		&models.TestSuite{},
+		&models.TestCase{},
+		&models.Mutant{},
 	)
 }
 
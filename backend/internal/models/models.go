@@ -69,6 +69,13 @@ type Project struct {
 	CoverageRate  float64 `json:"coverage_rate"`
 	UserID        uint    `json:"user_id"`
 
+	ExcludeFlakyLines bool `gorm:"default:false" json:"exclude_flaky_lines"` // Ignore flaky lines in quality gates and comparisons
+
+	// Badge colors: red below the low threshold, bright green from the high one (defaults 50 and 80)
+	BadgeLowThreshold  *float64 `json:"badge_low_threshold,omitempty"`
+	BadgeHighThreshold *float64 `json:"badge_high_threshold,omitempty"`
+	PrivateBadges      bool     `gorm:"default:false" json:"private_badges"` // Badges require a badge token
+
 	// Relationships
 	User          User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Builds        []Build        `gorm:"foreignKey:ProjectID" json:"builds,omitempty"`
@@ -114,6 +121,23 @@ type ProjectToken struct {
 	Project Project `gorm:"foreignKey:ProjectID" json:"-"`
 }
 
+// BadgeToken is a read-only token that unlocks the badges of a project with private badges.
+// Unlike project tokens it grants no API access, so it can be put in README image URLs.
+type BadgeToken struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	ProjectID string     `gorm:"type:varchar(36);not null;index" json:"project_id"`
+	Name      string     `gorm:"not null" json:"name"`
+	Token     string     `gorm:"uniqueIndex;not null" json:"token,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+
+	// Relationships
+	Project Project `gorm:"foreignKey:ProjectID" json:"-"`
+}
+
 // Build represents a coverage build for a project
 type Build struct {
 	ID        uint           `gorm:"primarykey" json:"id"`
@@ -128,6 +152,31 @@ type Build struct {
 	CommitMsg    string  `json:"commit_msg"`
 	CoverageRate float64 `json:"coverage_rate"`
 
+	BranchCoverageRate   *float64 `json:"branch_coverage_rate,omitempty"`   // Only set when branch data was uploaded
+	FunctionCoverageRate *float64 `json:"function_coverage_rate,omitempty"` // Only set when function data was uploaded
+
+	// Test results, only set when JUnit reports were uploaded
+	TestCount    *int     `json:"test_count,omitempty"`
+	TestsFailed  *int     `json:"tests_failed,omitempty"` // Failures and errors
+	TestsSkipped *int     `json:"tests_skipped,omitempty"`
+	TestDuration *float64 `json:"test_duration,omitempty"` // Seconds
+
+	MutationScore *float64 `json:"mutation_score,omitempty"` // Only set when a mutation report was uploaded
+
+	// CI provider metadata
+	ServiceName     string `json:"service_name"`
+	ServiceNumber   string `json:"service_number"`    // CI run number
+	ServiceBuildURL string `json:"service_build_url"` // Link to the CI run
+
+	// Patch coverage
+	BaseCommitSHA     string   `json:"base_commit_sha"`
+	ChangedLines      string   `gorm:"type:text" json:"-"` // JSON map of file name to changed line numbers
+	PatchCoverageRate *float64 `json:"patch_coverage_rate,omitempty"`
+
+	// Quality gate result
+	GateStatus  string `json:"gate_status"`        // passed, failed, or empty when no gate is configured
+	GateReasons string `gorm:"type:text" json:"-"` // JSON array of failure reasons
+
 	// Relationships
 	Project Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
 	Jobs    []Job   `gorm:"foreignKey:BuildID" json:"jobs,omitempty"`
@@ -142,9 +191,26 @@ type Job struct {
 
 	BuildID      uint    `gorm:"not null;index" json:"build_id"`
 	JobNumber    string  `json:"job_number"`
+	FlagName     string  `gorm:"index" json:"flag_name,omitempty"` // Coveralls flag, e.g. "unit" or "e2e"
 	CoverageRate float64 `json:"coverage_rate"`
 	Data         string  `gorm:"type:text" json:"data"` // JSON data
 
+	BranchCoverageRate   *float64 `json:"branch_coverage_rate,omitempty"`   // Only set when branch data was uploaded
+	FunctionCoverageRate *float64 `json:"function_coverage_rate,omitempty"` // Only set when function data was uploaded
+
+	// Test results, only set when JUnit reports were uploaded
+	TestCount    *int     `json:"test_count,omitempty"`
+	TestsFailed  *int     `json:"tests_failed,omitempty"` // Failures and errors
+	TestsSkipped *int     `json:"tests_skipped,omitempty"`
+	TestDuration *float64 `json:"test_duration,omitempty"` // Seconds
+
+	MutationScore *float64 `json:"mutation_score,omitempty"` // Only set when a mutation report was uploaded
+
+	// CI provider metadata
+	ServiceName   string `json:"service_name"`
+	ServiceNumber string `json:"service_number"`  // CI run number
+	ServiceJobURL string `json:"service_job_url"` // Link to the CI job log
+
 	// Relationships
 	Build Build     `gorm:"foreignKey:BuildID" json:"build,omitempty"`
 	Files []JobFile `gorm:"foreignKey:JobID" json:"files,omitempty"`
@@ -161,8 +227,149 @@ type JobFile struct {
 	Name         string  `gorm:"not null" json:"name"`
 	Coverage     string  `gorm:"type:text" json:"coverage"` // JSON array
 	Source       string  `gorm:"type:text" json:"source"`
+	SourceDigest string  `json:"source_digest"` // MD5 of the source
 	CoverageRate float64 `json:"coverage_rate"`
 
+	Branches             string   `gorm:"type:text" json:"branches,omitempty"` // JSON array of [line, block, branch, hits] quadruples
+	BranchCoverageRate   *float64 `json:"branch_coverage_rate,omitempty"`
+	FunctionCoverageRate *float64 `json:"function_coverage_rate,omitempty"`
+	Contexts             string   `gorm:"type:text" json:"-"` // JSON map of line number to the labels of the tests that hit it
+	MutationScore        *float64 `json:"mutation_score,omitempty"`
+
 	// Relationships
-	Job Job `gorm:"foreignKey:JobID" json:"job,omitempty"`
+	Job              Job            `gorm:"foreignKey:JobID" json:"job,omitempty"`
+	Functions        []FileFunction `gorm:"foreignKey:JobFileID" json:"functions,omitempty"`
+	SurvivingMutants []Mutant       `gorm:"foreignKey:JobFileID" json:"surviving_mutants,omitempty"`
+}
+
+// FileFunction is a function of a job file and the number of times it was called
+type FileFunction struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	JobFileID uint   `gorm:"not null;index" json:"job_file_id"`
+	Name      string `gorm:"not null" json:"name"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Hits      int    `json:"hits"`
+}
+
+// QualityGate holds the coverage rules the builds of a project must satisfy
+type QualityGate struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	ProjectID        string   `gorm:"type:varchar(36);not null;uniqueIndex" json:"project_id"`
+	MinCoverage      *float64 `json:"min_coverage"`       // Minimum total coverage
+	MaxDrop          *float64 `json:"max_drop"`           // Maximum drop versus the base branch, in percentage points
+	MinPatchCoverage *float64 `json:"min_patch_coverage"` // Minimum coverage of changed lines
+	BaseBranch       string   `json:"base_branch"`        // Defaults to the project's current branch
+
+	// Relationships
+	Project   Project               `gorm:"foreignKey:ProjectID" json:"-"`
+	PathRules []QualityGatePathRule `gorm:"foreignKey:QualityGateID" json:"path_rules"`
+}
+
+// QualityGatePathRule is a minimum coverage for the files under a path prefix
+type QualityGatePathRule struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	QualityGateID uint    `gorm:"not null;index" json:"quality_gate_id"`
+	Path          string  `gorm:"not null" json:"path"`
+	MinCoverage   float64 `json:"min_coverage"`
+}
+
+// FlakyLine is a line whose coverage toggles between builds while its file is unchanged
+type FlakyLine struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	ProjectID string `gorm:"type:varchar(36);not null;index" json:"project_id"`
+	Branch    string `json:"branch"`
+	FileName  string `gorm:"not null" json:"file_name"`
+	Line      int    `gorm:"not null" json:"line"`
+	Flips     int    `json:"flips"` // Number of covered/uncovered transitions seen
+
+	// Relationships
+	Project Project `gorm:"foreignKey:ProjectID" json:"-"`
+}
+
+// FunctionComplexity is the complexity of a function of a job file, as reported by a complexity tool
+type FunctionComplexity struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	JobFileID  uint   `gorm:"not null;index" json:"job_file_id"`
+	Name       string `gorm:"not null" json:"name"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	Metric     string `gorm:"not null" json:"metric"` // cyclomatic or cognitive
+	Complexity int    `json:"complexity"`
+
+	// Relationships
+	JobFile JobFile `gorm:"foreignKey:JobFileID" json:"-"`
+}
+
+// TestSuite is a JUnit test suite reported by a job
+type TestSuite struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	JobID    uint    `gorm:"not null;index" json:"job_id"`
+	Name     string  `json:"name"`
+	Tests    int     `json:"tests"`
+	Failures int     `json:"failures"`
+	Errors   int     `json:"errors"`
+	Skipped  int     `json:"skipped"`
+	Duration float64 `json:"duration"` // Seconds
+
+	// Relationships
+	Job   Job        `gorm:"foreignKey:JobID" json:"-"`
+	Cases []TestCase `gorm:"foreignKey:TestSuiteID" json:"cases,omitempty"`
+}
+
+// TestCase is a test of a JUnit test suite
+type TestCase struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	TestSuiteID uint    `gorm:"not null;index" json:"test_suite_id"`
+	ClassName   string  `json:"class_name"`
+	Name        string  `gorm:"not null" json:"name"`
+	Status      string  `gorm:"not null" json:"status"` // passed, failed, error or skipped
+	Duration    float64 `json:"duration"`               // Seconds
+	Message     string  `gorm:"type:text" json:"message,omitempty"`
+}
+
+// Mutant is a mutation of a job file reported by a mutation testing tool
+type Mutant struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	JobFileID   uint   `gorm:"not null;index" json:"job_file_id"`
+	MutantID    string `json:"mutant_id"` // ID in the report
+	MutatorName string `json:"mutator_name"`
+	Replacement string `gorm:"type:text" json:"replacement"`
+	Line        int    `gorm:"not null" json:"line"`
+	Column      int    `json:"column"`
+	EndLine     int    `json:"end_line"`
+	EndColumn   int    `json:"end_column"`
+	Status      string `gorm:"not null;index" json:"status"` // Killed, Survived, NoCoverage, Timeout, CompileError, RuntimeError or Ignored
 }
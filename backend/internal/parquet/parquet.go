@@ -0,0 +1,358 @@
+// Package parquet writes flat tables as Apache Parquet files.
+//
+// It implements the small subset of the format needed to stream tables of
+// scalars: uncompressed PLAIN encoded data pages, optional columns with RLE
+// definition levels, and row groups flushed as rows are written so that
+// memory use does not grow with the size of the table.
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+// errClosed is returned by the writes to a closed writer
+var errClosed = errors.New("parquet: writer is closed")
+
+// DefaultRowGroupSize is the number of rows buffered before a row group is written
+const DefaultRowGroupSize = 8192
+
+// Type is the type of the values of a column
+type Type int
+
+const (
+	Int64     Type = iota // int, int64 or uint values
+	Double                // float64 values
+	String                // string values, stored as UTF-8 byte arrays
+	Timestamp             // time.Time values, stored as milliseconds since the Unix epoch
+)
+
+// Parquet physical types, converted types, encodings and repetitions
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+)
+
+// Column describes a column of a table
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool // Whether the column accepts nil values
+}
+
+// physicalType returns the Parquet type of the column, and its converted type or -1
+func (c Column) physicalType() (physical, converted int32) {
+	switch c.Type {
+	case Double:
+		return typeDouble, -1
+	case String:
+		return typeByteArray, convertedUTF8
+	case Timestamp:
+		return typeInt64, convertedTimestampMillis
+	default:
+		return typeInt64, -1
+	}
+}
+
+// columnChunk buffers the values of a column for the current row group
+type columnChunk struct {
+	values []byte
+	levels []byte // Definition levels of optional columns: 1 for values, 0 for nulls
+}
+
+// chunkMeta locates a written column chunk
+type chunkMeta struct {
+	offset int64
+	size   int64
+	values int
+}
+
+// rowGroupMeta locates a written row group
+type rowGroupMeta struct {
+	rows    int
+	size    int64
+	columns []chunkMeta
+}
+
+// Writer streams rows to a Parquet file. Close must be called to write the file footer.
+type Writer struct {
+	RowGroupSize int // Rows per row group, DefaultRowGroupSize when zero
+
+	w         io.Writer
+	columns   []Column
+	chunks    []columnChunk
+	rows      int // Rows of the current row group
+	totalRows int64
+	offset    int64
+	groups    []rowGroupMeta
+	err       error
+}
+
+// NewWriter creates a writer of a table with the given columns
+func NewWriter(w io.Writer, columns []Column) *Writer {
+	return &Writer{w: w, columns: columns, chunks: make([]columnChunk, len(columns))}
+}
+
+// Write adds a row, with a value per column. Values must match the column
+// types, and may only be nil in optional columns.
+func (w *Writer) Write(row []any) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: row has %d values, expected %d", len(row), len(w.columns))
+	}
+
+	// Check the whole row before buffering it so that a bad value leaves the chunks aligned
+	for i, v := range row {
+		if v == nil && !w.columns[i].Optional {
+			return fmt.Errorf("parquet: column %s is required", w.columns[i].Name)
+		}
+		if v != nil && !acceptsValue(w.columns[i].Type, v) {
+			return fmt.Errorf("parquet: column %s does not accept %T values", w.columns[i].Name, v)
+		}
+	}
+
+	for i, v := range row {
+		chunk := &w.chunks[i]
+		if w.columns[i].Optional {
+			if v == nil {
+				chunk.levels = append(chunk.levels, 0)
+				continue
+			}
+			chunk.levels = append(chunk.levels, 1)
+		}
+		chunk.values = appendValue(chunk.values, v)
+	}
+
+	w.rows++
+	rowGroupSize := w.RowGroupSize
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+	if w.rows >= rowGroupSize {
+		w.err = w.flush()
+	}
+	return w.err
+}
+
+// Close writes the buffered rows and the file footer
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.rows > 0 {
+		if w.err = w.flush(); w.err != nil {
+			return w.err
+		}
+	}
+	if w.offset == 0 {
+		if w.err = w.write([]byte(magic)); w.err != nil {
+			return w.err
+		}
+	}
+
+	footer := w.fileMetaData()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, magic...)
+	if err := w.write(footer); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = errClosed
+	return nil
+}
+
+// acceptsValue reports whether a non-nil value can be stored in a column type
+func acceptsValue(typ Type, v any) bool {
+	switch v.(type) {
+	case int, int64, uint:
+		return typ == Int64
+	case float64:
+		return typ == Double
+	case string:
+		return typ == String
+	case time.Time:
+		return typ == Timestamp
+	}
+	return false
+}
+
+// appendValue PLAIN encodes a value
+func appendValue(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case int:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v))
+	case int64:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v))
+	case uint:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v))
+	case float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	case string:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+		return append(buf, v...)
+	case time.Time:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v.UnixMilli()))
+	}
+	return buf
+}
+
+// write writes to the underlying writer and tracks the file offset
+func (w *Writer) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	return err
+}
+
+// flush writes the buffered rows as a row group, with a single data page per column
+func (w *Writer) flush() error {
+	if w.offset == 0 {
+		if err := w.write([]byte(magic)); err != nil {
+			return err
+		}
+	}
+
+	group := rowGroupMeta{rows: w.rows, columns: make([]chunkMeta, len(w.columns))}
+	for i, column := range w.columns {
+		chunk := &w.chunks[i]
+
+		var page []byte
+		if column.Optional {
+			levels := encodeLevels(chunk.levels)
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+			page = append(page, levels...)
+		}
+		page = append(page, chunk.values...)
+
+		header := pageHeader(w.rows, len(page))
+		meta := chunkMeta{offset: w.offset, size: int64(len(header) + len(page)), values: w.rows}
+		if err := w.write(header); err != nil {
+			return err
+		}
+		if err := w.write(page); err != nil {
+			return err
+		}
+
+		group.columns[i] = meta
+		group.size += meta.size
+		chunk.values = chunk.values[:0]
+		chunk.levels = chunk.levels[:0]
+	}
+
+	w.groups = append(w.groups, group)
+	w.totalRows += int64(w.rows)
+	w.rows = 0
+	return nil
+}
+
+// encodeLevels encodes definition levels of bit width 1 as RLE runs
+func encodeLevels(levels []byte) []byte {
+	var buf []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		buf = append(buf, levels[i])
+		i = j
+	}
+	return buf
+}
+
+// pageHeader encodes the header of an uncompressed PLAIN data page
+func pageHeader(values, size int) []byte {
+	var t compactWriter
+	t.beginStruct()
+	t.i32Field(1, 0) // DATA_PAGE
+	t.i32Field(2, int32(size))
+	t.i32Field(3, int32(size))
+	t.structField(5)
+	t.i32Field(1, int32(values))
+	t.i32Field(2, encodingPlain)
+	t.i32Field(3, encodingRLE)
+	t.i32Field(4, encodingRLE)
+	t.endStruct()
+	t.endStruct()
+	return t.buf
+}
+
+// fileMetaData encodes the file footer
+func (w *Writer) fileMetaData() []byte {
+	var t compactWriter
+	t.beginStruct()
+	t.i32Field(1, 1) // Format version
+
+	t.listField(2, compactStruct, len(w.columns)+1)
+	t.beginStruct()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(w.columns)))
+	t.endStruct()
+	for _, column := range w.columns {
+		physical, converted := column.physicalType()
+		repetition := int32(repetitionRequired)
+		if column.Optional {
+			repetition = repetitionOptional
+		}
+		t.beginStruct()
+		t.i32Field(1, physical)
+		t.i32Field(3, repetition)
+		t.stringField(4, column.Name)
+		if converted >= 0 {
+			t.i32Field(6, converted)
+		}
+		t.endStruct()
+	}
+
+	t.i64Field(3, w.totalRows)
+
+	t.listField(4, compactStruct, len(w.groups))
+	for _, group := range w.groups {
+		t.beginStruct()
+		t.listField(1, compactStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			physical, _ := w.columns[i].physicalType()
+			t.beginStruct()
+			t.i64Field(2, chunk.offset)
+			t.structField(3)
+			t.i32Field(1, physical)
+			t.listField(2, compactI32, 2)
+			t.i32Elem(encodingPlain)
+			t.i32Elem(encodingRLE)
+			t.listField(3, compactBinary, 1)
+			t.stringElem(w.columns[i].Name)
+			t.i32Field(4, 0) // UNCOMPRESSED
+			t.i64Field(5, int64(chunk.values))
+			t.i64Field(6, chunk.size)
+			t.i64Field(7, chunk.size)
+			t.i64Field(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64Field(2, group.size)
+		t.i64Field(3, int64(group.rows))
+		t.endStruct()
+	}
+
+	t.stringField(6, "librecov")
+	t.endStruct()
+	return t.buf
+}
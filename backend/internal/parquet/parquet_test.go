@@ -0,0 +1,178 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// compactReader decodes Thrift compact structs into maps of field id to value,
+// to check the metadata of written files
+type compactReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *compactReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *compactReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) value(typ byte) any {
+	switch typ {
+	case compactI32, compactI64:
+		return r.zigzag()
+	case compactBinary:
+		n := int(r.varint())
+		s := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case compactList:
+		header := r.byte()
+		size, elemType := int(header>>4), header&0x0f
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.value(elemType)
+		}
+		return list
+	case compactStruct:
+		return r.readStruct()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *compactReader) readStruct() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+func TestWriter(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: Int64},
+		{Name: "name", Type: String},
+		{Name: "rate", Type: Double, Optional: true},
+		{Name: "created_at", Type: Timestamp},
+	}
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var out bytes.Buffer
+	w := NewWriter(&out, columns)
+	w.RowGroupSize = 2
+	rows := [][]any{
+		{1, "a.go", 50.0, created},
+		{int64(2), "pkg/b.go", nil, created},
+		{uint(3), "c.go", 75.5, created},
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Failed to write row: %v", err)
+		}
+	}
+	if err := w.Write([]any{4, nil, 1.0, created}); err == nil {
+		t.Errorf("Expected an error for a nil value in a required column")
+	}
+	if err := w.Write([]any{4, "d.go", "high", created}); err == nil {
+		t.Errorf("Expected an error for a value of the wrong type")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	if err := w.Write(rows[0]); err == nil {
+		t.Errorf("Expected an error writing to a closed writer")
+	}
+
+	file := out.Bytes()
+	if !bytes.HasPrefix(file, []byte(magic)) || !bytes.HasSuffix(file, []byte(magic)) {
+		t.Fatalf("Expected the file to start and end with %s", magic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := (&compactReader{buf: file[len(file)-8-footerLen : len(file)-8]}).readStruct()
+
+	if footer[3] != int64(3) {
+		t.Errorf("Expected 3 rows, got %v", footer[3])
+	}
+	schema := footer[2].([]any)
+	if len(schema) != 5 || schema[0].(map[int16]any)[5] != int64(4) {
+		t.Fatalf("Expected a root schema element with 4 children, got %v", schema)
+	}
+	rate := schema[3].(map[int16]any)
+	if rate[4] != "rate" || rate[1] != int64(typeDouble) || rate[3] != int64(repetitionOptional) {
+		t.Errorf("Unexpected schema of the rate column: %v", rate)
+	}
+	if name := schema[2].(map[int16]any); name[6] != int64(convertedUTF8) {
+		t.Errorf("Expected the name column to be UTF-8, got %v", name)
+	}
+
+	groups := footer[4].([]any)
+	if len(groups) != 2 || groups[0].(map[int16]any)[3] != int64(2) || groups[1].(map[int16]any)[3] != int64(1) {
+		t.Fatalf("Expected row groups of 2 and 1 rows, got %v", groups)
+	}
+
+	// Read back the rate column of the first row group
+	chunk := groups[0].(map[int16]any)[1].([]any)[2].(map[int16]any)[3].(map[int16]any)
+	offset := int(chunk[9].(int64))
+	page := &compactReader{buf: file, pos: offset}
+	header := page.readStruct()
+	if header[5].(map[int16]any)[1] != int64(2) {
+		t.Fatalf("Expected a data page of 2 values, got %v", header)
+	}
+	if size := int64(page.pos-offset) + header[3].(int64); chunk[7] != size {
+		t.Errorf("Expected a chunk size of %d, got %v", size, chunk[7])
+	}
+
+	levelsLen := int(binary.LittleEndian.Uint32(file[page.pos:]))
+	levels := file[page.pos+4 : page.pos+4+levelsLen]
+	if !bytes.Equal(levels, []byte{1 << 1, 1, 1 << 1, 0}) {
+		t.Errorf("Expected definition levels for a value then a null, got %v", levels)
+	}
+	value := math.Float64frombits(binary.LittleEndian.Uint64(file[page.pos+4+levelsLen:]))
+	if value != 50 {
+		t.Errorf("Expected the first rate to be 50, got %v", value)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := NewWriter(&out, []Column{{Name: "id", Type: Int64}}).Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	file := out.Bytes()
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	if len(file) != 4+footerLen+8 {
+		t.Fatalf("Expected only the magic and the footer, got %d bytes", len(file))
+	}
+	footer := (&compactReader{buf: file[4 : 4+footerLen]}).readStruct()
+	if footer[3] != int64(0) || len(footer[4].([]any)) != 0 {
+		t.Errorf("Expected no rows, got %v", footer)
+	}
+}
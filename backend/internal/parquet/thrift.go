@@ -0,0 +1,91 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol types
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter encodes the Thrift structures of Parquet metadata with the compact protocol
+type compactWriter struct {
+	buf   []byte
+	last  int16   // Last field id of the current struct
+	stack []int16 // Last field ids of the enclosing structs
+}
+
+func (w *compactWriter) varint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *compactWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+// field writes a field header, as a delta from the previous field id when possible
+func (w *compactWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.zigzag(int64(id))
+	}
+	w.last = id
+}
+
+func (w *compactWriter) i32Field(id int16, v int32) {
+	w.field(id, compactI32)
+	w.zigzag(int64(v))
+}
+
+func (w *compactWriter) i64Field(id int16, v int64) {
+	w.field(id, compactI64)
+	w.zigzag(v)
+}
+
+func (w *compactWriter) stringField(id int16, s string) {
+	w.field(id, compactBinary)
+	w.stringElem(s)
+}
+
+// listField writes the header of a list field, whose elements follow
+func (w *compactWriter) listField(id int16, elemType byte, size int) {
+	w.field(id, compactList)
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elemType)
+	} else {
+		w.buf = append(w.buf, 0xf0|elemType)
+		w.varint(uint64(size))
+	}
+}
+
+// structField starts a struct field, ended by endStruct
+func (w *compactWriter) structField(id int16) {
+	w.field(id, compactStruct)
+	w.beginStruct()
+}
+
+// beginStruct starts a struct, either top level, as a list element or as a field
+func (w *compactWriter) beginStruct() {
+	w.stack = append(w.stack, w.last)
+	w.last = 0
+}
+
+func (w *compactWriter) endStruct() {
+	w.buf = append(w.buf, 0) // Stop field
+	w.last = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
+
+func (w *compactWriter) i32Elem(v int32) {
+	w.zigzag(int64(v))
+}
+
+func (w *compactWriter) stringElem(s string) {
+	w.varint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
@@ -26,6 +26,10 @@ export interface Project {
   base_url: string
   coverage_rate: number
   user_id: number
+  exclude_flaky_lines?: boolean
+  badge_low_threshold?: number
+  badge_high_threshold?: number
+  private_badges?: boolean
   user?: User
   builds?: Build[]
   shares?: ProjectShare[]
@@ -50,6 +54,19 @@ export interface Build {
   commit_sha: string
   commit_msg: string
   coverage_rate: number
+  branch_coverage_rate?: number
+  function_coverage_rate?: number
+  test_count?: number
+  tests_failed?: number
+  tests_skipped?: number
+  test_duration?: number
+  mutation_score?: number
+  service_name?: string
+  service_number?: string
+  service_build_url?: string
+  base_commit_sha?: string
+  patch_coverage_rate?: number
+  gate_status?: string
   project?: Project
   jobs?: Job[]
   created_at: string
@@ -60,7 +77,18 @@ export interface Job {
   id: number
   build_id: number
   job_number: string
+  flag_name?: string
   coverage_rate: number
+  branch_coverage_rate?: number
+  function_coverage_rate?: number
+  test_count?: number
+  tests_failed?: number
+  tests_skipped?: number
+  test_duration?: number
+  mutation_score?: number
+  service_name?: string
+  service_number?: string
+  service_job_url?: string
   data: string
   build?: Build
   files?: JobFile[]
@@ -74,12 +102,41 @@ export interface JobFile {
   name: string
   coverage: string
   source: string
+  source_digest?: string
   coverage_rate: number
+  branches?: string
+  branch_coverage_rate?: number
+  function_coverage_rate?: number
+  functions?: FileFunction[]
+  mutation_score?: number
+  surviving_mutants?: Mutant[]
   job?: Job
   created_at: string
   updated_at: string
 }
 
+export interface FileFunction {
+  id: number
+  job_file_id: number
+  name: string
+  start_line: number
+  end_line: number
+  hits: number
+}
+
+export interface Mutant {
+  id: number
+  job_file_id: number
+  mutant_id: string
+  mutator_name: string
+  replacement: string
+  line: number
+  column: number
+  end_line: number
+  end_column: number
+  status: string
+}
+
 export interface RefreshSessionResponse {
   token: string
 }
@@ -92,3 +149,12 @@ export interface ProjectToken {
   created_at: string
   last_used?: string
 }
+
+export interface BadgeToken {
+  id: number
+  project_id: string
+  name: string
+  token?: string
+  created_at: string
+  last_used?: string
+}